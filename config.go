@@ -4,15 +4,28 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/azure"
 	"github.com/sylr/prometheus-azure-exporter/pkg/config"
 	"github.com/sylr/prometheus-azure-exporter/pkg/metrics"
+	"github.com/sylr/prometheus-azure-exporter/pkg/otlp"
+	"github.com/sylr/prometheus-azure-exporter/pkg/push"
+	"github.com/sylr/prometheus-azure-exporter/pkg/tracing"
+	"github.com/sylr/prometheus-azure-exporter/pkg/web"
 	"sylr.dev/libqd/cache"
 )
 
+var (
+	// Azure config and credential environment the Azure clients have been
+	// built from.
+	appliedAzureConfig      *config.AzureConfig
+	appliedAzureEnvironment map[string]string
+)
+
 func setConfig() error {
 	logger := log.WithFields(log.Fields{
 		"_id": "00000000",
@@ -28,6 +41,18 @@ func setConfig() error {
 
 	errs := config.ValidateConfig(conf)
 
+	// Web config
+	var webConf *web.Config
+	if len(conf.WebConfigFile) > 0 {
+		webConf, err = web.LoadFile(conf.WebConfigFile)
+
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			errs = append(errs, webConf.Validate()...)
+		}
+	}
+
 	if len(errs) > 0 {
 		for _, err := range errs {
 			logger.Error(err)
@@ -38,6 +63,14 @@ func setConfig() error {
 		return err
 	}
 
+	// Apply web configuration first as it is the only one which can fail
+	err = web.ApplyConfig(webConf)
+
+	if err != nil {
+		logger.Errorf("Configuration not applied because web config could not be applied: %s", err)
+		return err
+	}
+
 	// Apply configuration
 	err = applyConfig(conf)
 
@@ -62,6 +95,39 @@ func applyConfig(conf *config.PrometheusAzureExporterConfig) error {
 		})
 	}
 
+	// Push targets
+	push.ApplyConfig(config.CurrentConfig.Push)
+
+	// OTLP export
+	if err := otlp.ApplyConfig(config.CurrentConfig.OTLP, metrics.Gatherer()); err != nil {
+		log.Errorf("Unable to apply OTLP config: %s", err)
+		return err
+	}
+
+	// Tracing
+	if err := tracing.ApplyConfig(config.CurrentConfig.Tracing); err != nil {
+		log.Errorf("Unable to apply tracing config: %s", err)
+		return err
+	}
+
+	// Rebuild Azure clients with the new configuration
+	if err := azure.ApplyConfig(config.CurrentConfig.Azure); err != nil {
+		log.Errorf("Unable to apply azure config: %s", err)
+		return err
+	}
+
+	// Azure clients, credential and tokens are kept when neither their
+	// config nor the credential environment changed.
+	azureEnvironment := azure.CredentialEnvironment()
+
+	if appliedAzureConfig == nil || !reflect.DeepEqual(*appliedAzureConfig, config.CurrentConfig.Azure) || !reflect.DeepEqual(appliedAzureEnvironment, azureEnvironment) {
+		azure.ResetAzureClients()
+
+		azureConfig := config.CurrentConfig.Azure
+		appliedAzureConfig = &azureConfig
+		appliedAzureEnvironment = azureEnvironment
+	}
+
 	// Turn on Noop caching
 	if config.CurrentConfig.NoCache {
 		cache.SetNoop(true)
@@ -113,10 +179,27 @@ func watchConfigFile() {
 		log.Fatal(err)
 	}
 
+	if len(config.CurrentConfig.WebConfigFile) > 0 {
+		err = watcher.Add(config.CurrentConfig.WebConfigFile)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if len(os.Getenv("KUBERNETES_PORT")) > 0 {
 		dir := filepath.Dir(config.CurrentConfig.ConfigFile)
 		logger.Infof("In kubernetes context, adding %s to watch list", dir)
 		watcher.Add(dir)
+
+		if len(config.CurrentConfig.WebConfigFile) > 0 {
+			webDir := filepath.Dir(config.CurrentConfig.WebConfigFile)
+
+			if webDir != dir {
+				logger.Infof("In kubernetes context, adding %s to watch list", webDir)
+				watcher.Add(webDir)
+			}
+		}
 	}
 
 	if err != nil {
@@ -138,10 +221,14 @@ func watchConfigFile() {
 			if event.Op&fsnotify.Write == fsnotify.Write {
 				if event.Name == config.CurrentConfig.ConfigFile {
 					logger.Debugf("config: file changed")
+				} else if event.Name == config.CurrentConfig.WebConfigFile {
+					logger.Debugf("config: web file changed")
 				}
 			} else if event.Op&fsnotify.Create == fsnotify.Create {
 				if event.Name == config.CurrentConfig.ConfigFile {
 					logger.Debugf("config: file created")
+				} else if event.Name == config.CurrentConfig.WebConfigFile {
+					logger.Debugf("config: web file created")
 				} else if filepath.Base(event.Name) == "..data" {
 					logger.Debugf("config: configmap volume updated")
 				} else {
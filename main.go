@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	_ "net/http/pprof"
 	"os"
 	"runtime"
 
@@ -13,7 +12,9 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/sylr/prometheus-azure-exporter/pkg/config"
 	"github.com/sylr/prometheus-azure-exporter/pkg/metrics"
+	"github.com/sylr/prometheus-azure-exporter/pkg/push"
 	"github.com/sylr/prometheus-azure-exporter/pkg/tools"
+	"github.com/sylr/prometheus-azure-exporter/pkg/web"
 )
 
 var (
@@ -50,15 +51,22 @@ func init() {
 
 	// Register build info
 	prometheus.MustRegister(azureExporterBuildInfo)
+
+	// Push metrics after each successful update
+	metrics.RegisterUpdateMetricsFunctionHook(push.Push)
 }
 
 // main
 func main() {
-	// looping for --version in args
+	// looping for --version and --once in args
 	for _, val := range os.Args {
 		if val == "--version" {
 			fmt.Printf("prometheus-azure-exporter version %s\n", version)
 			os.Exit(0)
+		} else if val == "--once" {
+			// Keep stdout for the metrics in one-shot mode, this must be done
+			// before the configuration is parsed as it logs.
+			log.SetOutput(os.Stderr)
 		} else if val == "--" {
 			break
 		}
@@ -69,7 +77,6 @@ func main() {
 	if err != nil {
 		os.Exit(1)
 	}
-	go watchConfigFile()
 
 	// Log options
 	log.Debugf("Options: %+v", config.CurrentConfig)
@@ -78,6 +85,13 @@ func main() {
 	// Set build info
 	azureExporterBuildInfo.WithLabelValues(version, goVersion).Set(1)
 
+	// One-shot mode
+	if config.CurrentConfig.Once {
+		os.Exit(runOnce())
+	}
+
+	go watchConfigFile()
+
 	// Configure metrics update interval
 	metrics.SetDefaultUpdateMetricsInterval(config.CurrentConfig.UpdateInterval)
 
@@ -85,13 +99,50 @@ func main() {
 	ctx := context.Background()
 	go metrics.UpdateMetrics(ctx)
 
+	// Debug http endpoint
+	if len(config.CurrentConfig.DebugListeningAddress) > 0 {
+		go func() {
+			log.Infof("Debug server listening on %s", config.CurrentConfig.DebugListeningAddress)
+			err := http.ListenAndServe(config.CurrentConfig.DebugListeningAddress, web.NewDebugHandler())
+
+			if err != nil {
+				log.Errorf("Debug server stopped: %s", err)
+			}
+		}()
+	}
+
 	// Prometheus http endpoint
 	listeningAddress := fmt.Sprintf("%s:%d", config.CurrentConfig.ListeningAddress, config.CurrentConfig.ListeningPort)
-	http.Handle("/metrics", promhttp.Handler())
-	err = http.ListenAndServe(listeningAddress, nil)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", web.Handler(promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(metrics.Gatherer(), promhttp.HandlerOpts{}),
+	)))
+	for _, module := range metrics.Modules() {
+		mux.Handle("/metrics/"+module, web.Handler(promhttp.HandlerFor(metrics.Registry(module), promhttp.HandlerOpts{})))
+	}
+	mux.HandleFunc("/healthz", healthHandler(metrics.Alive))
+	mux.HandleFunc("/ready", healthHandler(metrics.Ready))
+	server := &http.Server{
+		Addr:    listeningAddress,
+		Handler: mux,
+	}
+	err = web.ListenAndServe(server)
 
 	if err != nil {
 		log.Fatal(err)
 		os.Exit(1)
 	}
 }
+
+// healthHandler returns an handler responding 200 if check succeeds, 503 otherwise.
+func healthHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintln(w, "OK")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+	"github.com/sylr/prometheus-azure-exporter/pkg/metrics"
+	"github.com/sylr/prometheus-azure-exporter/pkg/otlp"
+	"github.com/sylr/prometheus-azure-exporter/pkg/tracing"
+)
+
+// runOnce runs the update metrics functions once, writes the gathered
+// metrics and returns the exit code of the process.
+func runOnce() int {
+	output := config.CurrentConfig.OnceOutput
+	logger := log.WithFields(log.Fields{
+		"_id": "00000000",
+	})
+
+	exitCode := 0
+	err := metrics.RunUpdateMetricsFunctionsOnce(context.Background(), config.CurrentConfig.OnceFunctions)
+
+	if err != nil {
+		logger.Error(err)
+		exitCode = 1
+	}
+
+	// Flush metrics and spans to the OTLP collectors if configured
+	otlp.Shutdown()
+	tracing.Shutdown()
+
+	if output == "-" {
+		err = metrics.WriteText(os.Stdout)
+	} else {
+		err = metrics.WriteTextfile(output)
+	}
+
+	if err != nil {
+		logger.Errorf("Unable to write metrics: %s", err)
+		return 2
+	}
+
+	return exitCode
+}
@@ -1,127 +1,408 @@
 package azure
 
 import (
-	"os"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/azure"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
-	authorizer                    autorest.Authorizer
-	graphAuthorizer               autorest.Authorizer
-	batchAuthorizer               autorest.Authorizer
-	batchAuthorizerWithResource   autorest.Authorizer
-	storageAuthorizer             autorest.Authorizer
-	storageAuthorizerWithResource autorest.Authorizer
+	// AzureAPICredentialTokenExpireTime Gauge of the expiration time of the tokens
+	AzureAPICredentialTokenExpireTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure_api",
+			Subsystem: "credential",
+			Name:      "token_expire_time",
+			Help:      "Unix timestamp of the expiration of the current token of the exporter credential",
+		},
+		[]string{"credential", "resource"},
+	)
+
+	// AzureAPICredentialTokenLastRefreshTime Gauge of the last successful refresh time of the tokens
+	AzureAPICredentialTokenLastRefreshTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure_api",
+			Subsystem: "credential",
+			Name:      "token_last_refresh_time",
+			Help:      "Unix timestamp of the last successful refresh of the token of the exporter credential",
+		},
+		[]string{"credential", "resource"},
+	)
+
+	// AzureAPICredentialTokenRefreshFailuresTotal Total number of failed token refreshes
+	AzureAPICredentialTokenRefreshFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "azure_api",
+			Subsystem: "credential",
+			Name:      "token_refresh_failures_total",
+			Help:      "Total number of failed refreshes of the token of the exporter credential",
+		},
+		[]string{"credential", "resource"},
+	)
 )
 
-// GetAuthorizer get graph authorizer
-func GetAuthorizer() (autorest.Authorizer, error) {
-	if authorizer != nil {
-		return authorizer, nil
-	}
+var (
+	// Authorizers of the resources requested so far.
+	authorizers = newAuthorizerRegistry()
+)
+
+var (
+	// Mutex used to lock read/writes of authenticationFailures.
+	authenticationMutex = sync.Mutex{}
+	// Last authentication failure of each credential and resource.
+	authenticationFailures = make(map[authorizerKey]authenticationFailure)
+	// How long an authentication failure is reported by AuthenticationError.
+	authenticationFailureWindow = 5 * time.Minute
+)
 
-	var err error
+// authenticationFailure is an error encountered while authenticating against
+// Azure.
+type authenticationFailure struct {
+	err  error
+	time time.Time
+}
+
+func init() {
+	Registry.MustRegister(AzureAPICredentialTokenExpireTime)
+	Registry.MustRegister(AzureAPICredentialTokenLastRefreshTime)
+	Registry.MustRegister(AzureAPICredentialTokenRefreshFailuresTotal)
+}
 
-	authorizer, err = auth.NewAuthorizerFromEnvironment()
+// authorizerContextKey is the context key under which resourceAuthorizer
+// stores the authorizerKey a request has been authorized with.
+type authorizerContextKey struct{}
+
+// GetAuthorizer get resource manager authorizer
+func GetAuthorizer() (autorest.Authorizer, error) {
+	env, err := GetEnvironment()
 
 	if err != nil {
 		return nil, err
 	}
 
-	return authorizer, err
+	return GetAuthorizerWithResource(env.ResourceManagerEndpoint)
 }
 
 // GetGraphAuthorizer get graph authorizer
 func GetGraphAuthorizer() (autorest.Authorizer, error) {
-	if graphAuthorizer != nil {
-		return graphAuthorizer, nil
+	env, err := GetEnvironment()
+
+	if err != nil {
+		return nil, err
 	}
 
-	var err error
+	return GetAuthorizerWithResource(env.GraphEndpoint)
+}
 
-	envName := os.Getenv("AZURE_ENVIRONMENT")
+// GetBatchAuthorizer get batch authorizer
+func GetBatchAuthorizer() (autorest.Authorizer, error) {
+	return GetAuthorizer()
+}
+
+// GetBatchAuthorizerWithResource get batch authorizer with resource
+func GetBatchAuthorizerWithResource(resource string) (autorest.Authorizer, error) {
+	return GetAuthorizerWithResource(resource)
+}
+
+// GetStorageAuthorizer get storage authorizer
+func GetStorageAuthorizer() (autorest.Authorizer, error) {
+	return GetAuthorizer()
+}
 
-	if len(envName) == 0 {
-		envName = azure.PublicCloud.Name
+// GetStorageAuthorizerWithResource get storage authorizer with resource
+func GetStorageAuthorizerWithResource(resource string) (autorest.Authorizer, error) {
+	return GetAuthorizerWithResource(resource)
+}
+
+// GetAuthorizerWithResource returns an authorizer issuing tokens for resource.
+// The token is built from the credential on the first call so that errors
+// building it, e.g. an unreadable certificate, surface when the clients are
+// built. Azure is only contacted on the first request, which is when invalid
+// secrets or federated tokens surface.
+func GetAuthorizerWithResource(resource string) (autorest.Authorizer, error) {
+	if _, err := authorizers.get(resource); err != nil {
+		return nil, err
 	}
 
-	env, _ := azure.EnvironmentFromName(envName)
-	graphAuthorizer, err = auth.NewAuthorizerFromEnvironmentWithResource(env.GraphEndpoint)
+	return resourceAuthorizer{resource: resource}, nil
+}
+
+// RefreshAuthorizer forces the refresh of the token of resource.
+func RefreshAuthorizer(ctx context.Context, resource string) error {
+	entry, err := authorizers.get(resource)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return graphAuthorizer, err
+	err = entry.refresh(ctx)
+	recordAuthenticationError(entry.key, err)
+
+	return err
 }
 
-// GetBatchAuthorizer get batch authorizer
-func GetBatchAuthorizer() (autorest.Authorizer, error) {
-	if batchAuthorizer != nil {
-		return batchAuthorizer, nil
+// InvalidateAuthorizer drops the token of resource, a new one is acquired
+// from the credential on the next request.
+func InvalidateAuthorizer(resource string) {
+	authorizers.invalidate(resource)
+}
+
+// InvalidateAuthorizers drops the tokens of all the resources and forgets the
+// authentication failures, which may belong to a replaced credential.
+func InvalidateAuthorizers() {
+	authorizers.invalidateAll()
+
+	authenticationMutex.Lock()
+	authenticationFailures = make(map[authorizerKey]authenticationFailure)
+	authenticationMutex.Unlock()
+}
+
+// AuthenticationError returns the last error encountered while authenticating
+// against Azure within the failure window, whatever the credential and the
+// resource, or nil if there is none. Successful authentications for other
+// resources do not hide it, a successful one for the same resource does.
+func AuthenticationError() error {
+	authenticationMutex.Lock()
+	defer authenticationMutex.Unlock()
+
+	var last authenticationFailure
+	var lastKey authorizerKey
+
+	for key, failure := range authenticationFailures {
+		if time.Since(failure.time) > authenticationFailureWindow {
+			delete(authenticationFailures, key)
+			continue
+		}
+
+		if last.err == nil || failure.time.After(last.time) {
+			last, lastKey = failure, key
+		}
+	}
+
+	if last.err == nil {
+		return nil
+	}
+
+	if len(lastKey.resource) == 0 {
+		return last.err
 	}
 
-	var err error
+	return fmt.Errorf("%s: %w", lastKey.resource, last.err)
+}
+
+// recordAuthenticationError records the outcome of an authentication with the
+// credential and resource of key, a success clears the failure of key.
+func recordAuthenticationError(key authorizerKey, err error) {
+	authenticationMutex.Lock()
+	defer authenticationMutex.Unlock()
+
+	if err == nil {
+		delete(authenticationFailures, key)
+		return
+	}
+
+	authenticationFailures[key] = authenticationFailure{err: err, time: time.Now()}
+}
+
+// ----------------------------------------------------------------------------
+
+// authorizerKey identifies a token, the same resource can be requested with
+// several credentials.
+type authorizerKey struct {
+	credential string
+	resource   string
+}
+
+// authorizerRegistry holds one token per credential and resource so that
+// clients of different audiences never share a token.
+type authorizerRegistry struct {
+	mutex   sync.RWMutex
+	entries map[authorizerKey]*authorizerEntry
+}
+
+// authorizerEntry is the token of one credential and resource.
+type authorizerEntry struct {
+	mutex      sync.Mutex
+	key        authorizerKey
+	token      *adal.ServicePrincipalToken
+	authorizer autorest.Authorizer
+}
+
+func newAuthorizerRegistry() *authorizerRegistry {
+	return &authorizerRegistry{
+		entries: make(map[authorizerKey]*authorizerEntry),
+	}
+}
 
-	batchAuthorizer, err = auth.NewAuthorizerFromEnvironment()
+// get returns the entry of resource for the current credential, building
+// its token if needed.
+func (r *authorizerRegistry) get(resource string) (*authorizerEntry, error) {
+	cred, err := GetCredential()
 
 	if err != nil {
+		recordAuthenticationError(authorizerKey{resource: resource}, err)
 		return nil, err
 	}
 
-	return batchAuthorizer, err
-}
+	key := authorizerKey{credential: cred.Name(), resource: resource}
 
-// GetBatchAuthorizerWithResource get batch authorizer with resource
-func GetBatchAuthorizerWithResource(resource string) (autorest.Authorizer, error) {
-	if batchAuthorizerWithResource != nil {
-		return batchAuthorizerWithResource, nil
+	r.mutex.RLock()
+	entry, ok := r.entries[key]
+	r.mutex.RUnlock()
+
+	if ok {
+		return entry, nil
 	}
 
-	var err error
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-	batchAuthorizerWithResource, err = auth.NewAuthorizerFromEnvironmentWithResource(resource)
+	// Another goroutine may have created the entry meanwhile.
+	if entry, ok := r.entries[key]; ok {
+		return entry, nil
+	}
+
+	token, err := cred.Token(resource)
+	recordAuthenticationError(key, err)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return batchAuthorizerWithResource, err
+	// Tokens are refreshed through the configured proxy and CA.
+	token.SetSender(currentHTTPClient())
+
+	entry = &authorizerEntry{
+		key:        key,
+		token:      token,
+		authorizer: autorest.NewBearerAuthorizer(token),
+	}
+
+	token.SetRefreshCallbacks([]adal.TokenRefreshCallback{entry.observeRefresh})
+
+	r.entries[key] = entry
+
+	return entry, nil
 }
 
-// GetStorageAuthorizer get storage authorizer
-func GetStorageAuthorizer() (autorest.Authorizer, error) {
-	if storageAuthorizer != nil {
-		return storageAuthorizer, nil
+// invalidate removes the entries of resource whatever their credential.
+func (r *authorizerRegistry) invalidate(resource string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key := range r.entries {
+		if key.resource == resource {
+			delete(r.entries, key)
+		}
 	}
+}
+
+// invalidateAll removes all the entries.
+func (r *authorizerRegistry) invalidateAll() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-	var err error
+	r.entries = make(map[authorizerKey]*authorizerEntry)
+}
 
-	storageAuthorizer, err = auth.NewAuthorizerFromEnvironment()
+// refresh acquires a new token.
+func (e *authorizerEntry) refresh(ctx context.Context) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 
+	return e.observeRefreshError(e.token.RefreshWithContext(ctx))
+}
+
+// ensureFresh refreshes the token if it is about to expire.
+func (e *authorizerEntry) ensureFresh(ctx context.Context) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.observeRefreshError(e.token.EnsureFreshWithContext(ctx))
+}
+
+// observeRefresh is the adal refresh callback recording the new token.
+func (e *authorizerEntry) observeRefresh(token adal.Token) error {
+	AzureAPICredentialTokenExpireTime.WithLabelValues(e.key.credential, e.key.resource).Set(float64(token.Expires().Unix()))
+	AzureAPICredentialTokenLastRefreshTime.WithLabelValues(e.key.credential, e.key.resource).Set(float64(time.Now().Unix()))
+
+	return nil
+}
+
+// observeRefreshError counts refresh failures.
+func (e *authorizerEntry) observeRefreshError(err error) error {
 	if err != nil {
-		return nil, err
+		AzureAPICredentialTokenRefreshFailuresTotal.WithLabelValues(e.key.credential, e.key.resource).Inc()
 	}
 
-	return storageAuthorizer, err
+	return err
 }
 
-// GetStorageAuthorizerWithResource get storage authorizer with resource
-func GetStorageAuthorizerWithResource(resource string) (autorest.Authorizer, error) {
-	if storageAuthorizerWithResource != nil {
-		return storageAuthorizerWithResource, nil
+// ----------------------------------------------------------------------------
+
+// resourceAuthorizer is the autorest.Authorizer given to the clients. It looks
+// up the registry on each request so that invalidated tokens are replaced for
+// clients which have already been built, and keeps track of authentication
+// failures.
+type resourceAuthorizer struct {
+	resource string
+}
+
+// WithAuthorization returns a PrepareDecorator authorizing the request with
+// the token of the resource and recording the outcome.
+func (a resourceAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			entry, err := authorizers.get(a.resource)
+
+			if err != nil {
+				return r, err
+			}
+
+			// Refreshing here rather than in the bearer authorizer lets us
+			// keep track of refresh failures.
+			err = entry.ensureFresh(r.Context())
+			recordAuthenticationError(entry.key, err)
+
+			if err != nil {
+				return r, err
+			}
+
+			r, err = entry.authorizer.WithAuthorization()(p).Prepare(r)
+			recordAuthenticationError(entry.key, err)
+
+			if err != nil {
+				return r, err
+			}
+
+			// Keep the credential and resource at hand for respondUnauthorized.
+			return r.WithContext(context.WithValue(r.Context(), authorizerContextKey{}, entry.key)), nil
+		})
 	}
+}
 
-	var err error
+// respondUnauthorized records 401 responses as authentication errors and
+// invalidates the token used to authorize the request.
+func respondUnauthorized(resp *http.Response) {
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		return
+	}
 
-	storageAuthorizerWithResource, err = auth.NewAuthorizerFromEnvironmentWithResource(resource)
+	key, ok := authorizerKey{}, false
 
-	if err != nil {
-		return nil, err
+	if resp.Request != nil {
+		key, ok = resp.Request.Context().Value(authorizerContextKey{}).(authorizerKey)
 	}
 
-	return storageAuthorizerWithResource, err
+	recordAuthenticationError(key, fmt.Errorf("azure API responded %s", resp.Status))
+
+	if ok {
+		InvalidateAuthorizer(key.resource)
+	}
 }
@@ -0,0 +1,244 @@
+package azure
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// fakeCredential issues manual tokens whose access token is the resource.
+type fakeCredential struct {
+	mutex  sync.Mutex
+	issued map[string]int
+}
+
+func (c *fakeCredential) Name() string {
+	return "fake"
+}
+
+func (c *fakeCredential) Token(resource string) (*adal.ServicePrincipalToken, error) {
+	c.mutex.Lock()
+	c.issued[resource]++
+	c.mutex.Unlock()
+
+	oauthConfig, err := adal.NewOAuthConfig("https://login.example.com/", "tenant")
+
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := adal.NewServicePrincipalTokenFromManualToken(*oauthConfig, "client", resource, adal.Token{
+		AccessToken: resource,
+		ExpiresOn:   "4102444800",
+		Resource:    resource,
+		Type:        "Bearer",
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	token.SetAutoRefresh(false)
+
+	return token, nil
+}
+
+func setFakeCredential(t *testing.T) *fakeCredential {
+	cred := &fakeCredential{issued: make(map[string]int)}
+
+	credentialMutex.Lock()
+	previous := credential
+	credential = cred
+	credentialMutex.Unlock()
+
+	InvalidateAuthorizers()
+
+	t.Cleanup(func() {
+		credentialMutex.Lock()
+		credential = previous
+		credentialMutex.Unlock()
+
+		InvalidateAuthorizers()
+	})
+
+	return cred
+}
+
+func authorize(t *testing.T, a autorest.Authorizer) (*http.Request, string) {
+	r, err := autorest.Prepare(&http.Request{}, a.WithAuthorization())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, r.Header.Get("Authorization")
+}
+
+func TestAuthorizerRegistryResources(t *testing.T) {
+	setFakeCredential(t)
+
+	resources := []string{
+		"https://batch.core.windows.net/",
+		"https://storage.azure.com/",
+		"https://graph.windows.net/",
+	}
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		for _, resource := range resources {
+			wg.Add(1)
+
+			go func(resource string) {
+				defer wg.Done()
+
+				a, err := GetBatchAuthorizerWithResource(resource)
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				r, err := autorest.Prepare(&http.Request{}, a.WithAuthorization())
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if got, want := r.Header.Get("Authorization"), "Bearer "+resource; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}(resource)
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestAuthorizerRegistryInvalidate(t *testing.T) {
+	cred := setFakeCredential(t)
+	resource := "https://storage.azure.com/"
+
+	a, err := GetStorageAuthorizerWithResource(resource)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := authorize(t, a)
+	authorize(t, a)
+
+	if cred.issued[resource] != 1 {
+		t.Fatalf("expected 1 token, got %d", cred.issued[resource])
+	}
+
+	respondUnauthorized(&http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Status:     "401 Unauthorized",
+		Request:    r,
+	})
+
+	if AuthenticationError() == nil {
+		t.Error("expected an authentication error")
+	}
+
+	authorize(t, a)
+
+	if cred.issued[resource] != 2 {
+		t.Fatalf("expected 2 tokens, got %d", cred.issued[resource])
+	}
+}
+
+func TestAuthenticationErrorPerResource(t *testing.T) {
+	setFakeCredential(t)
+
+	storage := "https://storage.azure.com/"
+	batch := "https://batch.core.windows.net/"
+
+	storageAuthorizer, err := GetStorageAuthorizerWithResource(storage)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batchAuthorizer, err := GetBatchAuthorizerWithResource(batch)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := authorize(t, storageAuthorizer)
+
+	respondUnauthorized(&http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Status:     "401 Unauthorized",
+		Request:    r,
+	})
+
+	// Successful authentications for another resource do not clear it.
+	authorize(t, batchAuthorizer)
+	authorize(t, batchAuthorizer)
+
+	if err := AuthenticationError(); err == nil {
+		t.Fatal("expected an authentication error")
+	}
+
+	// Failures are forgotten after the window.
+	authenticationMutex.Lock()
+	for key, failure := range authenticationFailures {
+		failure.time = time.Now().Add(-authenticationFailureWindow - time.Second)
+		authenticationFailures[key] = failure
+	}
+	authenticationMutex.Unlock()
+
+	if err := AuthenticationError(); err != nil {
+		t.Errorf("expected no authentication error, got %v", err)
+	}
+}
+
+func TestAuthenticationErrorLatest(t *testing.T) {
+	setFakeCredential(t)
+
+	first := authorizerKey{credential: "fake", resource: "https://storage.azure.com/"}
+	second := authorizerKey{credential: "fake", resource: "https://batch.core.windows.net/"}
+
+	recordAuthenticationError(first, errors.New("first"))
+	recordAuthenticationError(second, errors.New("second"))
+	recordAuthenticationError(first, nil)
+
+	err := AuthenticationError()
+
+	if err == nil || err.Error() != "https://batch.core.windows.net/: second" {
+		t.Errorf("AuthenticationError() = %v, want the batch failure", err)
+	}
+
+	InvalidateAuthorizers()
+
+	if err := AuthenticationError(); err != nil {
+		t.Errorf("expected no authentication error after invalidation, got %v", err)
+	}
+}
+
+func TestAuthenticationErrorRecovered(t *testing.T) {
+	setFakeCredential(t)
+
+	key := authorizerKey{credential: "fake", resource: "https://storage.azure.com/"}
+
+	recordAuthenticationError(key, errors.New("refresh failed"))
+
+	if err := AuthenticationError(); err == nil {
+		t.Fatal("expected an authentication error")
+	}
+
+	recordAuthenticationError(key, nil)
+
+	if err := AuthenticationError(); err != nil {
+		t.Errorf("expected no authentication error after a success, got %v", err)
+	}
+}
@@ -9,6 +9,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+var (
+	// Registry holds all the azure_api_* metrics.
+	Registry = prometheus.NewRegistry()
+)
+
 var (
 	// AzureAPICallsTotal Total number of Azure API calls
 	AzureAPICallsTotal = prometheus.NewCounterVec(
@@ -18,7 +23,7 @@ var (
 			Name:      "calls_total",
 			Help:      "Total number of successful calls to the Azure API",
 		},
-		[]string{},
+		[]string{"service", "operation", "status_code"},
 	)
 
 	// AzureAPICallsFailedTotal Total number of failed Azure API calls
@@ -29,7 +34,7 @@ var (
 			Name:      "calls_failed_total",
 			Help:      "Total number of failed calls to the Azure API",
 		},
-		[]string{},
+		[]string{"service", "operation", "status_code"},
 	)
 
 	// AzureAPICallsDurationSecondsBuckets Histograms of Azure API calls durations in seconds
@@ -41,7 +46,7 @@ var (
 			Help:      "Histograms of successful Azure API calls durations in seconds",
 			Buckets:   []float64{0.02, 0.03, 0.04, 0.05, 0.10, 0.20, 0.30, 0.40, 0.50, 0.75, 1.0, 2.0},
 		},
-		[]string{},
+		[]string{"service", "operation", "status_code"},
 	)
 
 	// AzureAPITenantReadRateLimitRemaining Gauge describing the current number of remaining read API calls
@@ -116,26 +121,26 @@ var (
 )
 
 func init() {
-	prometheus.MustRegister(AzureAPICallsTotal)
-	prometheus.MustRegister(AzureAPICallsFailedTotal)
-	prometheus.MustRegister(AzureAPICallsDurationSecondsBuckets)
-	prometheus.MustRegister(AzureAPITenantReadRateLimitRemaining)
-	prometheus.MustRegister(AzureAPITenantWriteRateLimitRemaining)
-	prometheus.MustRegister(AzureAPISubscriptionReadRateLimitRemaining)
-	prometheus.MustRegister(AzureAPISubscriptionReadRateLimitLastUpdateTime)
-	prometheus.MustRegister(AzureAPISubscriptionWriteRateLimitRemaining)
-	prometheus.MustRegister(AzureAPISubscriptionWriteRateLimitLastUpdateTime)
+	Registry.MustRegister(AzureAPICallsTotal)
+	Registry.MustRegister(AzureAPICallsFailedTotal)
+	Registry.MustRegister(AzureAPICallsDurationSecondsBuckets)
+	Registry.MustRegister(AzureAPITenantReadRateLimitRemaining)
+	Registry.MustRegister(AzureAPITenantWriteRateLimitRemaining)
+	Registry.MustRegister(AzureAPISubscriptionReadRateLimitRemaining)
+	Registry.MustRegister(AzureAPISubscriptionReadRateLimitLastUpdateTime)
+	Registry.MustRegister(AzureAPISubscriptionWriteRateLimitRemaining)
+	Registry.MustRegister(AzureAPISubscriptionWriteRateLimitLastUpdateTime)
 }
 
 // ObserveAzureAPICall ...
-func ObserveAzureAPICall(duration float64) {
-	AzureAPICallsTotal.WithLabelValues().Inc()
-	AzureAPICallsDurationSecondsBuckets.WithLabelValues().Observe(duration)
+func ObserveAzureAPICall(duration float64, labels ...string) {
+	AzureAPICallsTotal.WithLabelValues(labels...).Inc()
+	AzureAPICallsDurationSecondsBuckets.WithLabelValues(labels...).Observe(duration)
 }
 
 // ObserveAzureAPICallFailed ...
-func ObserveAzureAPICallFailed(duration float64) {
-	AzureAPICallsFailedTotal.WithLabelValues().Inc()
+func ObserveAzureAPICallFailed(duration float64, labels ...string) {
+	AzureAPICallsFailedTotal.WithLabelValues(labels...).Inc()
 }
 
 // SetReadRateLimitRemaining ...
@@ -149,6 +154,8 @@ func SetReadRateLimitRemaining(tenant string, subscription string, response *htt
 			azureAPIRateMutex.Lock()
 			AzureAPITenantReadRateLimitRemaining.WithLabelValues(tenant).Set(f)
 			azureAPIRateMutex.Unlock()
+
+			apiThrottler.observe(rateLimitKey{"tenant", tenant, false}, f)
 		}
 	}
 
@@ -162,6 +169,8 @@ func SetReadRateLimitRemaining(tenant string, subscription string, response *htt
 			AzureAPISubscriptionReadRateLimitRemaining.WithLabelValues(subscription).Set(f)
 			AzureAPISubscriptionReadRateLimitLastUpdateTime.WithLabelValues(subscription).Set(float64(time.Now().Unix()))
 			azureAPIRateMutex.Unlock()
+
+			apiThrottler.observe(rateLimitKey{"subscription", subscription, false}, f)
 		}
 	}
 }
@@ -177,6 +186,8 @@ func SetWriteRateLimitRemaining(tenant string, subscription string, response *ht
 			azureAPIRateMutex.Lock()
 			AzureAPITenantWriteRateLimitRemaining.WithLabelValues(tenant).Set(f)
 			azureAPIRateMutex.Unlock()
+
+			apiThrottler.observe(rateLimitKey{"tenant", tenant, true}, f)
 		}
 	}
 
@@ -190,6 +201,8 @@ func SetWriteRateLimitRemaining(tenant string, subscription string, response *ht
 			AzureAPISubscriptionWriteRateLimitRemaining.WithLabelValues(subscription).Set(f)
 			AzureAPISubscriptionWriteRateLimitLastUpdateTime.WithLabelValues(subscription).Set(float64(time.Now().Unix()))
 			azureAPIRateMutex.Unlock()
+
+			apiThrottler.observe(rateLimitKey{"subscription", subscription, true}, f)
 		}
 	}
 }
@@ -28,7 +28,7 @@ var (
 			Name:      "calls_total",
 			Help:      "Total number of calls to the Azure API",
 		},
-		[]string{"subscription", "resource_group", "account"},
+		[]string{"subscription", "resource_group", "account", "service", "operation", "status_code"},
 	)
 
 	// AzureAPIBatchCallsFailedTotal Total number of failed Azure Batch API calls
@@ -39,7 +39,7 @@ var (
 			Name:      "calls_failed_total",
 			Help:      "Total number of failed calls to the Azure API",
 		},
-		[]string{"subscription", "resource_group", "account"},
+		[]string{"subscription", "resource_group", "account", "service", "operation", "status_code"},
 	)
 
 	// AzureAPIBatchCallsDurationSecondsBuckets Histograms of Azure Batch API calls durations in seconds
@@ -51,14 +51,14 @@ var (
 			Help:      "Histograms of successful Azure Batch API calls durations in seconds",
 			Buckets:   []float64{0.02, 0.03, 0.04, 0.05, 0.10, 0.20, 0.30, 0.40, 0.50, 0.75, 1.0, 2.0},
 		},
-		[]string{"subscription", "resource_group", "account"},
+		[]string{"subscription", "resource_group", "account", "service", "operation", "status_code"},
 	)
 )
 
 func init() {
-	prometheus.MustRegister(AzureAPIBatchCallsTotal)
-	prometheus.MustRegister(AzureAPIBatchCallsFailedTotal)
-	prometheus.MustRegister(AzureAPIBatchCallsDurationSecondsBuckets)
+	Registry.MustRegister(AzureAPIBatchCallsTotal)
+	Registry.MustRegister(AzureAPIBatchCallsFailedTotal)
+	Registry.MustRegister(AzureAPIBatchCallsDurationSecondsBuckets)
 }
 
 // ObserveAzureBatchAPICall ...
@@ -72,6 +72,21 @@ func ObserveAzureBatchAPICallFailed(duration float64, labels ...string) {
 	AzureAPIBatchCallsFailedTotal.WithLabelValues(labels...).Inc()
 }
 
+// batchAPICall describes a call made by client for account.
+func batchAPICall(service string, client string, operation string, subscription *subscription.Model, accountDetails *ResourceDetails, account *azurebatch.Account) apiCall {
+	return apiCall{
+		service:        service,
+		client:         client,
+		operation:      operation,
+		subscriptionID: accountDetails.SubscriptionID,
+		resourceGroup:  accountDetails.ResourceGroup,
+		account:        *account.Name,
+		observe:        ObserveAzureBatchAPICall,
+		observeFailed:  ObserveAzureBatchAPICallFailed,
+		labels:         []string{*subscription.DisplayName, accountDetails.ResourceGroup, *account.Name},
+	}
+}
+
 // ListSubscriptionBatchAccounts List all subscription batch accounts
 func ListSubscriptionBatchAccounts(ctx context.Context, clients *AzureClients, subscription *subscription.Model) (*[]azurebatch.Account, error) {
 	c := cache.GetCache(5*time.Minute, time.Minute)
@@ -90,6 +105,16 @@ func ListSubscriptionBatchAccounts(ctx context.Context, clients *AzureClients, s
 		}
 	}
 
+	if inventoryEnabled() {
+		accounts, err := inventoryBatchAccounts(ctx, clients, *subscription.SubscriptionID)
+
+		if err == nil {
+			return accounts, nil
+		}
+
+		contextLogger.Warnf("Failed to list batch accounts from the inventory, falling back to resource manager: %s", err)
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 
@@ -99,17 +124,22 @@ func ListSubscriptionBatchAccounts(ctx context.Context, clients *AzureClients, s
 		return nil, err
 	}
 
-	t0 := time.Now()
-	accounts, err := client.List(ctx)
-	t1 := time.Since(t0).Seconds()
+	var accounts azurebatch.AccountListResultPage
+
+	err = observeAPICall(ctx, apiCall{
+		service:        serviceResourceManager,
+		client:         "batch.AccountClient",
+		operation:      "List",
+		subscriptionID: *subscription.SubscriptionID,
+	}, func(ctx context.Context) (err error) {
+		accounts, err = client.List(ctx)
+		return err
+	})
 
 	if err != nil {
-		ObserveAzureAPICallFailed(t1)
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-
 	vals := accounts.Values()
 	c.SetDefault(cacheKey, &vals)
 
@@ -146,21 +176,17 @@ func ListBatchAccountPools(ctx context.Context, clients *AzureClients, subscript
 		return nil, err
 	}
 
-	t0 := time.Now()
-	pools, err := client.ListByBatchAccount(ctx, accountDetails.ResourceGroup, *account.Name, nil, "", "")
-	t1 := time.Since(t0).Seconds()
+	var pools azurebatch.ListPoolsResultPage
+
+	err = observeAPICall(ctx, batchAPICall(serviceResourceManager, "batch.PoolClient", "ListByBatchAccount", subscription, accountDetails, account), func(ctx context.Context) (err error) {
+		pools, err = client.ListByBatchAccount(ctx, accountDetails.ResourceGroup, *account.Name, nil, "", "")
+		return err
+	})
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-			ObserveAzureBatchAPICallFailed(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-	ObserveAzureBatchAPICall(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
-
 	vals := pools.Values()
 	c.SetDefault(cacheKey, vals)
 
@@ -191,38 +217,41 @@ func ListBatchAccountJobs(ctx context.Context, clients *AzureClients, subscripti
 	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 
-	client, err := clients.GetBatchJobClientWithResource(*account.AccountEndpoint, "https://batch.core.windows.net/")
+	env, err := GetEnvironment()
+
 	if err != nil {
 		return nil, err
 	}
 
-	t0 := time.Now()
-	cloudJobs, err := client.List(ctx, "", "", "", nil, nil, nil, nil, nil)
-	t1 := time.Since(t0).Seconds()
+	client, err := clients.GetBatchJobClientWithResource(*account.AccountEndpoint, batchResource(env))
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-			ObserveAzureBatchAPICallFailed(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-	ObserveAzureBatchAPICall(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
+	var cloudJobs batch.CloudJobListResultPage
+
+	err = observeAPICall(ctx, batchAPICall(serviceBatch, "batch.JobClient", "List", subscription, accountDetails, account), func(ctx context.Context) (err error) {
+		cloudJobs, err = client.List(ctx, "", "", "", nil, nil, nil, nil, nil)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
 
 	jobs := make([]batch.CloudJob, 0)
 
 	for {
 		jobs = append(jobs, cloudJobs.Values()...)
 
-		if cloudJobs.NotDone() {
-			err := cloudJobs.Next()
+		more, err := fetchNextPage(ctx, batchAPICall(serviceBatch, "batch.JobClient", "List", subscription, accountDetails, account), &cloudJobs, cloudJobs.Response().OdataNextLink)
 
-			if err != nil {
-				return nil, err
-			}
-		} else {
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
 			break
 		}
 	}
@@ -238,70 +267,122 @@ func GetBatchJobTaskCounts(ctx context.Context, clients *AzureClients, subscript
 	defer cancel()
 
 	accountDetails, _ := ParseResourceID(*account.ID)
-	client, err := clients.GetBatchJobClientWithResource(*account.AccountEndpoint, "https://batch.core.windows.net/")
+	env, err := GetEnvironment()
 
 	if err != nil {
 		return nil, err
 	}
 
-	t0 := time.Now()
-	taskCounts, err := client.GetTaskCounts(ctx, *job.ID, nil, nil, nil, nil)
-	t1 := time.Since(t0).Seconds()
+	client, err := clients.GetBatchJobClientWithResource(*account.AccountEndpoint, batchResource(env))
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-			ObserveAzureBatchAPICallFailed(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-	ObserveAzureBatchAPICall(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
+	var taskCounts batch.TaskCounts
+
+	err = observeAPICall(ctx, batchAPICall(serviceBatch, "batch.JobClient", "GetTaskCounts", subscription, accountDetails, account), func(ctx context.Context) (err error) {
+		taskCounts, err = client.GetTaskCounts(ctx, *job.ID, nil, nil, nil, nil)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
 
 	return &taskCounts, nil
 }
 
+// ListBatchAccountPoolNodeCounts list the dedicated and low priority node
+// counts by state of all the account pools
+func ListBatchAccountPoolNodeCounts(ctx context.Context, clients *AzureClients, subscription *subscription.Model, account *azurebatch.Account) ([]batch.PoolNodeCounts, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	accountDetails, _ := ParseResourceID(*account.ID)
+	env, err := GetEnvironment()
+
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := clients.GetBatchDataAccountClientWithResource(*account.AccountEndpoint, batchResource(env))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var poolNodeCounts batch.PoolNodeCountsListResultPage
+
+	err = observeAPICall(ctx, batchAPICall(serviceBatch, "batch.AccountClient", "ListPoolNodeCounts", subscription, accountDetails, account), func(ctx context.Context) (err error) {
+		poolNodeCounts, err = client.ListPoolNodeCounts(ctx, "", nil, nil, nil, nil, nil)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]batch.PoolNodeCounts, 0)
+
+	for {
+		counts = append(counts, poolNodeCounts.Values()...)
+
+		more, err := fetchNextPage(ctx, batchAPICall(serviceBatch, "batch.AccountClient", "ListPoolNodeCounts", subscription, accountDetails, account), &poolNodeCounts, poolNodeCounts.Response().OdataNextLink)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	return counts, nil
+}
+
 // ListBatchComputeNodes get job tasks metrics
 func ListBatchComputeNodes(ctx context.Context, clients *AzureClients, subscription *subscription.Model, account *azurebatch.Account, pool *azurebatch.Pool) (*[]batch.ComputeNode, error) {
 	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 
 	accountDetails, _ := ParseResourceID(*account.ID)
-	client, err := clients.GetBatchComputeNodeClientWithResource(*account.AccountEndpoint, "https://batch.core.windows.net/")
-	//client, err := clients.GetBatchComputeNodeClient(*account.AccountEndpoint)
+	env, err := GetEnvironment()
 
 	if err != nil {
 		return nil, err
 	}
 
-	t0 := time.Now()
-	computeNodes, err := client.List(ctx, *pool.Name, "", "", nil, nil, nil, nil, nil)
-	t1 := time.Since(t0).Seconds()
+	client, err := clients.GetBatchComputeNodeClientWithResource(*account.AccountEndpoint, batchResource(env))
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-			ObserveAzureBatchAPICallFailed(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-	ObserveAzureBatchAPICall(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
+	var computeNodes batch.ComputeNodeListResultPage
+
+	err = observeAPICall(ctx, batchAPICall(serviceBatch, "batch.ComputeNodeClient", "List", subscription, accountDetails, account), func(ctx context.Context) (err error) {
+		computeNodes, err = client.List(ctx, *pool.Name, "", "", nil, nil, nil, nil, nil)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
 
 	nodes := make([]batch.ComputeNode, 0)
 
 	for {
 		nodes = append(nodes, computeNodes.Values()...)
 
-		if computeNodes.NotDone() {
-			err := computeNodes.Next()
+		more, err := fetchNextPage(ctx, batchAPICall(serviceBatch, "batch.ComputeNodeClient", "List", subscription, accountDetails, account), &computeNodes, computeNodes.Response().OdataNextLink)
 
-			if err != nil {
-				return nil, err
-			}
-		} else {
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
 			break
 		}
 	}
@@ -0,0 +1,71 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	azurebatch "github.com/Azure/azure-sdk-for-go/services/batch/mgmt/2019-08-01/batch"
+	"github.com/Azure/azure-sdk-for-go/services/preview/subscription/mgmt/2018-03-01-preview/subscription"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+func TestListBatchAccountPoolNodeCounts(t *testing.T) {
+	setFakeCredential(t)
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Query().Get("page") != "2" {
+			fmt.Fprintf(w, `{"value":[{"poolId":"pool-1","dedicated":{"idle":2,"running":1}}],"odata.nextLink":"%s/account/nodecounts?page=2"}`, server.URL)
+			return
+		}
+
+		fmt.Fprint(w, `{"value":[{"poolId":"pool-2","lowPriority":{"preempted":3}}]}`)
+	}))
+	defer server.Close()
+
+	clients := NewAzureClientsWithHTTPClient(server.Client(), config.EndpointsConfig{
+		Batch: server.URL + "/{account}",
+	})
+
+	subscriptionID := "00000000-0000-0000-0000-000000000000"
+	subscriptionName := "subscription"
+	accountID := "/subscriptions/" + subscriptionID + "/resourceGroups/rg/providers/Microsoft.Batch/batchAccounts/account"
+	accountName := "account"
+	accountEndpoint := "account.westeurope.batch.azure.com"
+
+	ctx := context.WithValue(context.Background(), "id", "0a1b2c3d")
+	counts, err := ListBatchAccountPoolNodeCounts(ctx, clients,
+		&subscription.Model{SubscriptionID: &subscriptionID, DisplayName: &subscriptionName},
+		&azurebatch.Account{ID: &accountID, Name: &accountName, AccountProperties: &azurebatch.AccountProperties{AccountEndpoint: &accountEndpoint}},
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(counts) != 2 {
+		t.Fatalf("got %d pool node counts, want 2", len(counts))
+	}
+
+	if id := counts[0].PoolID; id == nil || *id != "pool-1" {
+		t.Errorf("counts[0].PoolID = %v, want pool-1", id)
+	}
+
+	if dedicated := counts[0].Dedicated; dedicated == nil || dedicated.Idle == nil || *dedicated.Idle != 2 || dedicated.Running == nil || *dedicated.Running != 1 {
+		t.Errorf("counts[0].Dedicated = %+v, want 2 idle and 1 running nodes", dedicated)
+	}
+
+	if id := counts[1].PoolID; id == nil || *id != "pool-2" {
+		t.Errorf("counts[1].PoolID = %v, want pool-2", id)
+	}
+
+	if lowPriority := counts[1].LowPriority; lowPriority == nil || lowPriority.Preempted == nil || *lowPriority.Preempted != 3 {
+		t.Errorf("counts[1].LowPriority = %+v, want 3 preempted nodes", lowPriority)
+	}
+}
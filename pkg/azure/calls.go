@@ -0,0 +1,178 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Services of the calls.
+	serviceResourceManager = "resource_manager"
+	serviceBatch           = "batch"
+	serviceStorage         = "storage"
+	serviceGraph           = "graph"
+)
+
+// apiCall describes a call to the Azure API observed by observeAPICall.
+type apiCall struct {
+	// Service called, one of the service* constants.
+	service string
+	// Client and operation called, e.g. batch.PoolClient and ListByBatchAccount.
+	client    string
+	operation string
+	// Attributes of the span.
+	subscriptionID string
+	resourceGroup  string
+	account        string
+	// Observers of the metrics specific to the call, if any, and their leading
+	// labels. The service, operation and status_code labels are appended.
+	observe       func(duration float64, labels ...string)
+	observeFailed func(duration float64, labels ...string)
+	labels        []string
+}
+
+// apiCallStatusKey is the context key under which observeAPICall stores the
+// status of the call so that the request and response inspectors can record it.
+type apiCallStatusKey struct{}
+
+// apiCallStatus holds the status code and the request ids of the last request
+// of a call.
+type apiCallStatus struct {
+	mutex           sync.Mutex
+	code            int
+	clientRequestID string
+	requestID       string
+}
+
+// apiCallStatusFromContext returns the status of the call ctx has been made
+// for, nil if none.
+func apiCallStatusFromContext(ctx context.Context) *apiCallStatus {
+	status, _ := ctx.Value(apiCallStatusKey{}).(*apiCallStatus)
+	return status
+}
+
+func (s *apiCallStatus) setClientRequestID(id string) {
+	s.mutex.Lock()
+	s.clientRequestID = id
+	s.mutex.Unlock()
+}
+
+func (s *apiCallStatus) setResponse(resp *http.Response) {
+	s.mutex.Lock()
+	s.code = resp.StatusCode
+	s.requestID = responseRequestID(resp)
+	s.mutex.Unlock()
+}
+
+// statusCode returns the status code of the last response, 0 if none.
+func (s *apiCallStatus) statusCode() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.code
+}
+
+// label returns the status code as a label value, `none` if no response has
+// been received.
+func (s *apiCallStatus) label() string {
+	if code := s.statusCode(); code > 0 {
+		return strconv.Itoa(code)
+	}
+
+	return "none"
+}
+
+// fields returns the log fields of the call.
+func (s *apiCallStatus) fields() log.Fields {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return log.Fields{
+		"status_code":       s.code,
+		"client_request_id": s.clientRequestID,
+		"request_id":        s.requestID,
+	}
+}
+
+// observeAPICall runs f in a span and records its duration and outcome in the
+// azure_api_calls_* metrics and in the metrics specific to the call. The
+// context given to f must be used for the requests of the call.
+func observeAPICall(ctx context.Context, call apiCall, f func(ctx context.Context) error) error {
+	status := &apiCallStatus{}
+	ctx = context.WithValue(ctx, apiCallStatusKey{}, status)
+	ctx, span := startSpan(ctx, call.client+"."+call.operation, call.subscriptionID, call.resourceGroup, call.account)
+
+	t0 := time.Now()
+	err := f(ctx)
+	t1 := time.Since(t0).Seconds()
+
+	if code := status.statusCode(); code > 0 {
+		setSpanStatusCode(span, code)
+	}
+
+	endSpan(span, err)
+
+	labels := append(append(make([]string, 0, len(call.labels)+3), call.labels...), call.service, call.operation, status.label())
+
+	if err != nil {
+		if ctx.Err() != context.Canceled {
+			// Ids to be given to the Azure support.
+			log.WithFields(log.Fields{
+				"_id":   ctx.Value("id"),
+				"_func": ctx.Value("func"),
+			}).WithFields(status.fields()).Warnf("Azure API call %s.%s failed: %s", call.client, call.operation, err)
+
+			ObserveAzureAPICallFailed(t1, call.service, call.operation, status.label())
+
+			if call.observeFailed != nil {
+				call.observeFailed(t1, labels...)
+			}
+		}
+
+		return err
+	}
+
+	ObserveAzureAPICall(t1, call.service, call.operation, status.label())
+
+	if call.observe != nil {
+		call.observe(t1, labels...)
+	}
+
+	return nil
+}
+
+// pager is a page of a paginated list of the Azure SDK.
+type pager interface {
+	NextWithContext(ctx context.Context) error
+}
+
+// fetchNextPage fetches the page following page in an observed call when
+// nextLink points to one. It returns false when there is no next page.
+func fetchNextPage(ctx context.Context, call apiCall, page pager, nextLink *string) (bool, error) {
+	if nextLink == nil || len(*nextLink) == 0 {
+		return false, nil
+	}
+
+	if err := observeAPICall(ctx, call, page.NextWithContext); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// recordAPICallStatus records the status code and the request id of resp on
+// the call its request has been made for.
+func recordAPICallStatus(resp *http.Response) {
+	if resp == nil || resp.Request == nil {
+		return
+	}
+
+	if status := apiCallStatusFromContext(resp.Request.Context()); status != nil {
+		status.setResponse(resp)
+	}
+}
@@ -0,0 +1,220 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sylr/prometheus-azure-exporter/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testPager is a page of a list served by an httptest server which gives the
+// link to the next page in the Link header.
+type testPager struct {
+	header   string
+	nextLink *string
+}
+
+func (p *testPager) NextWithContext(ctx context.Context) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, *p.nextLink, nil)
+	req, err := autorest.Prepare(req, requestInspect(p.header))
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := autorest.Send(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	recordAPICallStatus(resp)
+
+	p.nextLink = nil
+
+	if link := resp.Header.Get("Link"); len(link) > 0 {
+		p.nextLink = &link
+	}
+
+	return nil
+}
+
+// newTestPagesServer returns a server of a list of pages pages, from
+// /?page=0 on, whose requests are sent to received.
+func newTestPagesServer(pages int, received chan<- *http.Request) *httptest.Server {
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+
+		if page+1 < pages {
+			w.Header().Set("Link", server.URL+"/?page="+strconv.Itoa(page+1))
+		}
+	}))
+
+	return server
+}
+
+func TestObserveAPICall(t *testing.T) {
+	respond := func(statusCode int, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://management.azure.com", nil)
+			recordAPICallStatus(&http.Response{StatusCode: statusCode, Request: req})
+			return err
+		}
+	}
+
+	var familyLabels []string
+	call := apiCall{
+		service:   serviceBatch,
+		client:    "batch.JobClient",
+		operation: "TestGetTaskCounts",
+		observe: func(duration float64, labels ...string) {
+			familyLabels = labels
+		},
+		observeFailed: func(duration float64, labels ...string) {
+			familyLabels = labels
+		},
+		labels: []string{"subscription", "group", "account"},
+	}
+
+	if err := observeAPICall(context.Background(), call, respond(http.StatusOK, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(AzureAPICallsTotal.WithLabelValues(serviceBatch, "TestGetTaskCounts", "200")); got != 1 {
+		t.Errorf("calls_total{status_code=200} = %v, want 1", got)
+	}
+
+	want := []string{"subscription", "group", "account", serviceBatch, "TestGetTaskCounts", "200"}
+	if len(familyLabels) != len(want) {
+		t.Fatalf("family labels = %v, want %v", familyLabels, want)
+	}
+
+	for i := range want {
+		if familyLabels[i] != want[i] {
+			t.Fatalf("family labels = %v, want %v", familyLabels, want)
+		}
+	}
+
+	if err := observeAPICall(context.Background(), call, respond(http.StatusTooManyRequests, errors.New("throttled"))); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if got := testutil.ToFloat64(AzureAPICallsFailedTotal.WithLabelValues(serviceBatch, "TestGetTaskCounts", "429")); got != 1 {
+		t.Errorf("calls_failed_total{status_code=429} = %v, want 1", got)
+	}
+
+	// No response received.
+	if err := observeAPICall(context.Background(), call, func(ctx context.Context) error { return errors.New("dial") }); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if got := testutil.ToFloat64(AzureAPICallsFailedTotal.WithLabelValues(serviceBatch, "TestGetTaskCounts", "none")); got != 1 {
+		t.Errorf("calls_failed_total{status_code=none} = %v, want 1", got)
+	}
+}
+
+func TestFetchNextPage(t *testing.T) {
+	received := make(chan *http.Request, 3)
+	server := newTestPagesServer(3, received)
+	defer server.Close()
+
+	call := apiCall{service: serviceBatch, client: "batch.JobClient", operation: "TestListPages"}
+	first := server.URL + "/?page=0"
+	page := &testPager{nextLink: &first}
+
+	if err := observeAPICall(context.Background(), call, page.NextWithContext); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := 1
+
+	for {
+		more, err := fetchNextPage(context.Background(), call, page, page.nextLink)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !more {
+			break
+		}
+
+		pages++
+	}
+
+	if pages != 3 {
+		t.Errorf("pages = %d, want 3", pages)
+	}
+
+	if got := testutil.ToFloat64(AzureAPICallsTotal.WithLabelValues(serviceBatch, "TestListPages", "200")); got != 3 {
+		t.Errorf("calls_total = %v, want 3", got)
+	}
+}
+
+func TestObserveAPICallSpan(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	ctx, root := tracing.Tracer().Start(context.Background(), "UpdateMetricsFunction batch")
+
+	for _, statusCode := range []int{http.StatusOK, http.StatusNotFound} {
+		call := apiCall{service: serviceBatch, client: "batch.PoolClient", operation: "TestList", account: "account"}
+
+		observeAPICall(ctx, call, func(ctx context.Context) error {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://management.azure.com", nil)
+			recordAPICallStatus(&http.Response{StatusCode: statusCode, Request: req})
+			return nil
+		})
+	}
+
+	root.End()
+
+	spans := recorder.Ended()
+
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+
+	for i, want := range []int64{http.StatusOK, http.StatusNotFound} {
+		span := spans[i]
+
+		if span.Name() != "batch.PoolClient.TestList" || span.SpanKind() != trace.SpanKindClient {
+			t.Errorf("span %d = %s (%s), want a client span batch.PoolClient.TestList", i, span.Name(), span.SpanKind())
+		}
+
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("span %d is not parented to the update metrics function span", i)
+		}
+
+		got := map[attribute.Key]attribute.Value{}
+
+		for _, attr := range span.Attributes() {
+			got[attr.Key] = attr.Value
+		}
+
+		if got["http.response.status_code"].AsInt64() != want {
+			t.Errorf("span %d status code = %v, want %d", i, got["http.response.status_code"].Emit(), want)
+		}
+
+		if got["azure.account"].AsString() != "account" {
+			t.Errorf("span %d account = %q, want account", i, got["azure.account"].AsString())
+		}
+	}
+}
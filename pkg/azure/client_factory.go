@@ -0,0 +1,171 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// AzureAPIClientsCreatedTotal Total number of Azure clients created
+	AzureAPIClientsCreatedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "azure_api",
+			Subsystem: "",
+			Name:      "clients_created_total",
+			Help:      "Total number of Azure API clients created",
+		},
+		[]string{"client"},
+	)
+
+	// AzureAPIConnectionsTotal Total number of connections used by the Azure clients
+	AzureAPIConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "azure_api",
+			Subsystem: "",
+			Name:      "connections_total",
+			Help:      "Total number of connections obtained by the Azure API clients, reused or newly established",
+		},
+		[]string{"reused"},
+	)
+)
+
+func init() {
+	Registry.MustRegister(AzureAPIClientsCreatedTotal)
+	Registry.MustRegister(AzureAPIConnectionsTotal)
+}
+
+// clientOptions describes how the clients of a cache are configured.
+type clientOptions struct {
+	// Name of the client in azure_api_clients_created_total.
+	name string
+	// Returns the authorizer of the client.
+	authorizer func() (autorest.Authorizer, error)
+	// Response inspector of the client.
+	responseInspector autorest.RespondDecorator
+	// Inspector of the responses of each attempt, retries included, if any.
+	sendInspector autorest.SendDecorator
+	// Header of the client request id, x-ms-client-request-id if empty.
+	clientRequestIDHeader string
+}
+
+// clientCache is a concurrency safe cache of clients of type T.
+type clientCache[T any] struct {
+	mutex   sync.RWMutex
+	clients map[string]*T
+	sender  *http.Client
+}
+
+// newClientCache makes a new clientCache whose clients send their requests
+// with sender, the autorest default sender if nil.
+func newClientCache[T any](sender *http.Client) *clientCache[T] {
+	return &clientCache[T]{
+		clients: make(map[string]*T),
+		sender:  sender,
+	}
+}
+
+// get returns the client cached under key. If there is none, it is made with
+// newClient which returns the new client along with its autorest.Client so
+// that it can be configured with opts.
+func (c *clientCache[T]) get(key string, opts clientOptions, newClient func(env azure.Environment) (*T, *autorest.Client)) (*T, error) {
+	c.mutex.RLock()
+	client, ok := c.clients[key]
+	c.mutex.RUnlock()
+
+	if ok {
+		return client, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Another goroutine may have made the client meanwhile.
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+
+	env, err := GetEnvironment()
+
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := opts.authorizer()
+
+	if err != nil {
+		return nil, err
+	}
+
+	policy := currentRetryPolicy()
+
+	client, autorestClient := newClient(env)
+	autorestClient.RetryAttempts = policy.maxAttempts
+	autorestClient.RetryDuration = policy.backoff
+	// The send decorators replace the ones of the generated clients, including
+	// azure.DoRetryWithRegistration. It is dropped on purpose: registering a
+	// resource provider needs write permissions the exporter should not have,
+	// it only reads resources of providers already in use, and the decorator
+	// retries on its own which would multiply the attempts of the policy.
+	autorestClient.SkipResourceProviderRegistration = true
+	autorestClient.SendDecorators = make([]autorest.SendDecorator, 0, 2)
+
+	if opts.sendInspector != nil {
+		autorestClient.SendDecorators = append(autorestClient.SendDecorators, opts.sendInspector)
+	}
+
+	// The last decorator is the outermost, retries wrap the inspector.
+	autorestClient.SendDecorators = append(autorestClient.SendDecorators, policy.sendDecorator())
+	autorestClient.Authorizer = auth
+	autorestClient.ResponseInspector = opts.responseInspector
+	autorestClient.RequestInspector = requestInspect(opts.clientRequestIDHeader)
+
+	if c.sender != nil {
+		autorestClient.Sender = c.sender
+	}
+
+	c.clients[key] = client
+	AzureAPIClientsCreatedTotal.WithLabelValues(opts.name).Inc()
+
+	return client, nil
+}
+
+// requestInspect sets the client request id of the requests in header and
+// counts the connections they use and whether they have been reused.
+func requestInspect(header string) autorest.PrepareDecorator {
+	if len(header) == 0 {
+		header = clientRequestIDHeader
+	}
+
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+
+			if err != nil {
+				return r, err
+			}
+
+			setClientRequestID(r, header)
+
+			return r.WithContext(withConnectionTrace(r.Context())), nil
+		})
+	}
+}
+
+// withConnectionTrace returns a copy of ctx counting the connections used by
+// the requests made with it.
+func withConnectionTrace(ctx context.Context) context.Context {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			AzureAPIConnectionsTotal.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+
+	return httptrace.WithClientTrace(ctx, trace)
+}
@@ -1,405 +1,391 @@
 package azure
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/batch/2019-08-01.10.0/batch"
 	azurebatch "github.com/Azure/azure-sdk-for-go/services/batch/mgmt/2019-08-01/batch"
 	graph "github.com/Azure/azure-sdk-for-go/services/graphrbac/1.6/graphrbac"
 	"github.com/Azure/azure-sdk-for-go/services/preview/subscription/mgmt/2018-03-01-preview/subscription"
+	"github.com/Azure/azure-sdk-for-go/services/resourcegraph/mgmt/2019-04-01/resourcegraph"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
 	log "github.com/sirupsen/logrus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
 )
 
-// AzureClients Collection of Azure clients
+var (
+	// Mutex used to lock read/writes of sharedClients.
+	sharedClientsMutex = sync.Mutex{}
+	// AzureClients shared by all the update metrics functions runs.
+	sharedClients *AzureClients
+)
+
+// clientsContextKey is the context key of the AzureClients of a run.
+type clientsContextKey struct{}
+
+// AzureClients Collection of Azure clients, it is safe for concurrent use.
 type AzureClients struct {
-	mutex                       sync.RWMutex
-	batchAccountClients         map[string]*azurebatch.AccountClient
-	batchPoolClients            map[string]*azurebatch.PoolClient
-	batchJobClients             map[string]*batch.JobClient
-	batchComputeNodeClient      map[string]*batch.ComputeNodeClient
-	subscriptionsClients        map[string]*subscription.SubscriptionsClient
-	applicationsClients         map[string]*graph.ApplicationsClient
-	servicePrincipalsClients    map[string]*graph.ServicePrincipalsClient
-	storageAccountsClients      map[string]*storage.AccountsClient
-	storageAccountUsagesClients map[string]*storage.UsagesClient
-	blobContainersClients       map[string]*storage.BlobContainersClient
-	groupClients                map[string]*resources.GroupsClient
-}
-
-// NewAzureClients makes new AzureClients object
+	httpClient                  *http.Client
+	endpoints                   config.EndpointsConfig
+	batchAccountClients         *clientCache[azurebatch.AccountClient]
+	batchPoolClients            *clientCache[azurebatch.PoolClient]
+	batchJobClients             *clientCache[batch.JobClient]
+	batchComputeNodeClients     *clientCache[batch.ComputeNodeClient]
+	batchDataAccountClients     *clientCache[batch.AccountClient]
+	subscriptionsClients        *clientCache[subscription.SubscriptionsClient]
+	applicationsClients         *clientCache[graph.ApplicationsClient]
+	storageAccountsClients      *clientCache[storage.AccountsClient]
+	storageAccountUsagesClients *clientCache[storage.UsagesClient]
+	blobContainersClients       *clientCache[storage.BlobContainersClient]
+	groupClients                *clientCache[resources.GroupsClient]
+	resourceGraphClients        *clientCache[resourcegraph.BaseClient]
+}
+
+// NewAzureClients makes new AzureClients object using the http client and
+// the endpoints of the current configuration.
 func NewAzureClients() *AzureClients {
+	return NewAzureClientsWithHTTPClient(currentHTTPClient(), currentEndpoints())
+}
+
+// NewAzureClientsWithHTTPClient makes new AzureClients object whose clients
+// send their requests with httpClient and reach the given endpoints instead of
+// the ones of the Azure environment when they are set.
+func NewAzureClientsWithHTTPClient(httpClient *http.Client, endpoints config.EndpointsConfig) *AzureClients {
 	azc := &AzureClients{
-		mutex:                       sync.RWMutex{},
-		batchAccountClients:         make(map[string]*azurebatch.AccountClient),
-		batchPoolClients:            make(map[string]*azurebatch.PoolClient),
-		batchJobClients:             make(map[string]*batch.JobClient),
-		batchComputeNodeClient:      make(map[string]*batch.ComputeNodeClient),
-		subscriptionsClients:        make(map[string]*subscription.SubscriptionsClient),
-		applicationsClients:         make(map[string]*graph.ApplicationsClient),
-		servicePrincipalsClients:    make(map[string]*graph.ServicePrincipalsClient),
-		storageAccountsClients:      make(map[string]*storage.AccountsClient),
-		storageAccountUsagesClients: make(map[string]*storage.UsagesClient),
-		blobContainersClients:       make(map[string]*storage.BlobContainersClient),
-		groupClients:                make(map[string]*resources.GroupsClient),
+		httpClient:                  httpClient,
+		endpoints:                   endpoints,
+		batchAccountClients:         newClientCache[azurebatch.AccountClient](httpClient),
+		batchPoolClients:            newClientCache[azurebatch.PoolClient](httpClient),
+		batchJobClients:             newClientCache[batch.JobClient](httpClient),
+		batchComputeNodeClients:     newClientCache[batch.ComputeNodeClient](httpClient),
+		batchDataAccountClients:     newClientCache[batch.AccountClient](httpClient),
+		subscriptionsClients:        newClientCache[subscription.SubscriptionsClient](httpClient),
+		applicationsClients:         newClientCache[graph.ApplicationsClient](httpClient),
+		storageAccountsClients:      newClientCache[storage.AccountsClient](httpClient),
+		storageAccountUsagesClients: newClientCache[storage.UsagesClient](httpClient),
+		blobContainersClients:       newClientCache[storage.BlobContainersClient](httpClient),
+		groupClients:                newClientCache[resources.GroupsClient](httpClient),
+		resourceGraphClients:        newClientCache[resourcegraph.BaseClient](httpClient),
 	}
 
 	return azc
 }
 
-// GetSubscriptionClient return subscription client
-func (azc *AzureClients) GetSubscriptionClient(subscriptionID string) (*subscription.SubscriptionsClient, error) {
-	if _, ok := azc.subscriptionsClients[subscriptionID]; ok {
-		return azc.subscriptionsClients[subscriptionID], nil
+// HTTPClient returns the http client used by the clients.
+func (azc *AzureClients) HTTPClient() *http.Client {
+	if azc.httpClient == nil {
+		return http.DefaultClient
 	}
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetBatchAuthorizer()
+	return azc.httpClient
+}
 
-	if err != nil {
-		return nil, err
-	}
+// resourceManagerURI returns the base URI of the resource manager clients.
+func (azc *AzureClients) resourceManagerURI(env azure.Environment) string {
+	return overrideEndpoint(azc.endpoints.ResourceManager, "", resourceManagerURI(env))
+}
 
-	client := subscription.NewSubscriptionsClient()
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.subscriptionsClients[subscriptionID] = &client
-	azc.subscriptionsClients[subscriptionID].Authorizer = auth
-	azc.subscriptionsClients[subscriptionID].ResponseInspector = respondInspect(subscriptionID)
+// graphURI returns the base URI of the graph clients.
+func (azc *AzureClients) graphURI(env azure.Environment) string {
+	return overrideEndpoint(azc.endpoints.Graph, "", graphURI(env))
+}
 
-	return azc.subscriptionsClients[subscriptionID], nil
+// batchURI returns the base URI of the data plane clients of the batch
+// account reached at accountEndpoint.
+func (azc *AzureClients) batchURI(accountEndpoint string) string {
+	return overrideEndpoint(azc.endpoints.Batch, batchAccountName(accountEndpoint), "https://"+accountEndpoint)
 }
 
-// GetGroupClient return group client
-func (azc *AzureClients) GetGroupClient(subscriptionID string) (*resources.GroupsClient, error) {
-	if _, ok := azc.groupClients[subscriptionID]; ok {
-		return azc.groupClients[subscriptionID], nil
-	}
+// batchAccountName returns the name of the batch account of accountEndpoint.
+func batchAccountName(accountEndpoint string) string {
+	return strings.SplitN(accountEndpoint, ".", 2)[0]
+}
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
+// blobServiceURL returns the URL of the blob service of a storage account.
+func (azc *AzureClients) blobServiceURL(env azure.Environment, account string) string {
+	return overrideEndpoint(azc.endpoints.Blob, account, blobServiceURL(env, account))
+}
 
-	auth, err := GetAuthorizer()
+// GetAzureClients returns the AzureClients shared by all the runs so that
+// clients, connection pools and TLS sessions are kept between runs.
+func GetAzureClients() *AzureClients {
+	sharedClientsMutex.Lock()
+	defer sharedClientsMutex.Unlock()
 
-	if err != nil {
-		return nil, err
+	if sharedClients == nil {
+		sharedClients = NewAzureClients()
 	}
 
-	client := resources.NewGroupsClient(subscriptionID)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.groupClients[subscriptionID] = &client
-	azc.groupClients[subscriptionID].Authorizer = auth
-	azc.groupClients[subscriptionID].ResponseInspector = respondInspect(subscriptionID)
-
-	return azc.groupClients[subscriptionID], nil
+	return sharedClients
 }
 
-// GetBatchAccountClient return batch account client for specific subscription
-func (azc *AzureClients) GetBatchAccountClient(subscriptionID string) (*azurebatch.AccountClient, error) {
-	if _, ok := azc.batchAccountClients[subscriptionID]; ok {
-		return azc.batchAccountClients[subscriptionID], nil
-	}
+// ResetAzureClients drops the shared AzureClients along with the credential
+// and the tokens, they are built again on next use. It is meant to be called
+// when the configuration changes.
+func ResetAzureClients() {
+	sharedClientsMutex.Lock()
+	sharedClients = nil
+	sharedClientsMutex.Unlock()
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
+	ResetCredential()
+	InvalidateAuthorizers()
+}
 
-	auth, err := GetBatchAuthorizer()
+// ContextWithAzureClients returns a copy of ctx holding clients.
+func ContextWithAzureClients(ctx context.Context, clients *AzureClients) context.Context {
+	return context.WithValue(ctx, clientsContextKey{}, clients)
+}
 
-	if err != nil {
-		return nil, err
+// AzureClientsFromContext returns the AzureClients held by ctx, the shared
+// AzureClients if there are none.
+func AzureClientsFromContext(ctx context.Context) *AzureClients {
+	if clients, ok := ctx.Value(clientsContextKey{}).(*AzureClients); ok {
+		return clients
 	}
 
-	client := azurebatch.NewAccountClient(subscriptionID)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.batchAccountClients[subscriptionID] = &client
-	azc.batchAccountClients[subscriptionID].Authorizer = auth
-	azc.batchAccountClients[subscriptionID].ResponseInspector = respondInspect(subscriptionID)
-
-	return azc.batchAccountClients[subscriptionID], nil
+	return GetAzureClients()
 }
 
-// GetBatchPoolClient get batch pool client
-func (azc *AzureClients) GetBatchPoolClient(subscriptionID string) (*azurebatch.PoolClient, error) {
-	if _, ok := azc.batchPoolClients[subscriptionID]; ok {
-		return azc.batchPoolClients[subscriptionID], nil
+// GetSubscriptionClient return subscription client
+func (azc *AzureClients) GetSubscriptionClient(subscriptionID string) (*subscription.SubscriptionsClient, error) {
+	opts := clientOptions{
+		name:              "subscription",
+		authorizer:        GetAuthorizer,
+		responseInspector: respondInspect(subscriptionID),
 	}
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetBatchAuthorizer()
+	return azc.subscriptionsClients.get(subscriptionID, opts, func(env azure.Environment) (*subscription.SubscriptionsClient, *autorest.Client) {
+		client := subscription.NewSubscriptionsClientWithBaseURI(azc.resourceManagerURI(env))
+		return &client, &client.Client
+	})
+}
 
-	if err != nil {
-		return nil, err
+// GetGroupClient return group client
+func (azc *AzureClients) GetGroupClient(subscriptionID string) (*resources.GroupsClient, error) {
+	opts := clientOptions{
+		name:              "group",
+		authorizer:        GetAuthorizer,
+		responseInspector: respondInspect(subscriptionID),
 	}
 
-	client := azurebatch.NewPoolClient(subscriptionID)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.batchPoolClients[subscriptionID] = &client
-	azc.batchPoolClients[subscriptionID].Authorizer = auth
-	azc.batchPoolClients[subscriptionID].ResponseInspector = respondInspect(subscriptionID)
+	return azc.groupClients.get(subscriptionID, opts, func(env azure.Environment) (*resources.GroupsClient, *autorest.Client) {
+		client := resources.NewGroupsClientWithBaseURI(azc.resourceManagerURI(env), subscriptionID)
+		return &client, &client.Client
+	})
+}
+
+// GetBatchAccountClient return batch account client for specific subscription
+func (azc *AzureClients) GetBatchAccountClient(subscriptionID string) (*azurebatch.AccountClient, error) {
+	opts := clientOptions{
+		name:              "batch_account",
+		authorizer:        GetBatchAuthorizer,
+		responseInspector: respondInspect(subscriptionID),
+	}
 
-	return azc.batchPoolClients[subscriptionID], nil
+	return azc.batchAccountClients.get(subscriptionID, opts, func(env azure.Environment) (*azurebatch.AccountClient, *autorest.Client) {
+		client := azurebatch.NewAccountClientWithBaseURI(azc.resourceManagerURI(env), subscriptionID)
+		return &client, &client.Client
+	})
 }
 
-// GetBatchJobClient get batch job client
-func (azc *AzureClients) GetBatchJobClient(accountEndpoint string) (*batch.JobClient, error) {
-	if _, ok := azc.batchJobClients[accountEndpoint]; ok {
-		return azc.batchJobClients[accountEndpoint], nil
+// GetBatchPoolClient get batch pool client
+func (azc *AzureClients) GetBatchPoolClient(subscriptionID string) (*azurebatch.PoolClient, error) {
+	opts := clientOptions{
+		name:              "batch_pool",
+		authorizer:        GetBatchAuthorizer,
+		responseInspector: respondInspect(subscriptionID),
 	}
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
+	return azc.batchPoolClients.get(subscriptionID, opts, func(env azure.Environment) (*azurebatch.PoolClient, *autorest.Client) {
+		client := azurebatch.NewPoolClientWithBaseURI(azc.resourceManagerURI(env), subscriptionID)
+		return &client, &client.Client
+	})
+}
 
-	auth, err := GetBatchAuthorizer()
+// GetBatchJobClient get batch job client
+func (azc *AzureClients) GetBatchJobClient(accountEndpoint string) (*batch.JobClient, error) {
+	env, err := GetEnvironment()
 
 	if err != nil {
 		return nil, err
 	}
 
-	client := batch.NewJobClient("https://" + accountEndpoint)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.batchJobClients[accountEndpoint] = &client
-	azc.batchJobClients[accountEndpoint].Authorizer = auth
-	// azc.batchJobClients[accountEndpoint].ResponseInspector = respondInspectDebug()
-
-	return azc.batchJobClients[accountEndpoint], nil
+	return azc.GetBatchJobClientWithResource(accountEndpoint, batchResource(env))
 }
 
 // GetBatchJobClientWithResource get job client with resource
 func (azc *AzureClients) GetBatchJobClientWithResource(accountEndpoint string, resource string) (*batch.JobClient, error) {
-	if _, ok := azc.batchJobClients[accountEndpoint+resource]; ok {
-		return azc.batchJobClients[accountEndpoint+resource], nil
+	opts := clientOptions{
+		name: "batch_job",
+		authorizer: func() (autorest.Authorizer, error) {
+			return GetBatchAuthorizerWithResource(resource)
+		},
+		responseInspector:     respondTrace(),
+		sendInspector:         sendInspectDataPlane(serviceBatch, batchAccountName(accountEndpoint)),
+		clientRequestIDHeader: batchClientRequestIDHeader,
 	}
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetBatchAuthorizerWithResource(resource)
-
-	if err != nil {
-		return nil, err
-	}
-
-	client := batch.NewJobClient("https://" + accountEndpoint)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.batchJobClients[accountEndpoint+resource] = &client
-	azc.batchJobClients[accountEndpoint+resource].Authorizer = auth
-	// azc.batchJobClients[accountEndpoint+resource].ResponseInspector = respondInspectDebug()
-
-	return azc.batchJobClients[accountEndpoint+resource], nil
+	return azc.batchJobClients.get(accountEndpoint+resource, opts, func(env azure.Environment) (*batch.JobClient, *autorest.Client) {
+		client := batch.NewJobClient(azc.batchURI(accountEndpoint))
+		return &client, &client.Client
+	})
 }
 
 // GetBatchComputeNodeClient get compute node client
 func (azc *AzureClients) GetBatchComputeNodeClient(accountEndpoint string) (*batch.ComputeNodeClient, error) {
-	if _, ok := azc.batchComputeNodeClient[accountEndpoint]; ok {
-		return azc.batchComputeNodeClient[accountEndpoint], nil
-	}
-
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetBatchAuthorizer()
+	env, err := GetEnvironment()
 
 	if err != nil {
 		return nil, err
 	}
 
-	client := batch.NewComputeNodeClient("https://" + accountEndpoint)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.batchComputeNodeClient[accountEndpoint] = &client
-	azc.batchComputeNodeClient[accountEndpoint].Authorizer = auth
-	// azc.batchJobClients[accountEndpoint].ResponseInspector = respondInspectDebug()
-
-	return azc.batchComputeNodeClient[accountEndpoint], nil
+	return azc.GetBatchComputeNodeClientWithResource(accountEndpoint, batchResource(env))
 }
 
 // GetBatchComputeNodeClientWithResource get compute node client with resource
 func (azc *AzureClients) GetBatchComputeNodeClientWithResource(accountEndpoint string, resource string) (*batch.ComputeNodeClient, error) {
-	if _, ok := azc.batchComputeNodeClient[accountEndpoint+resource]; ok {
-		return azc.batchComputeNodeClient[accountEndpoint+resource], nil
+	opts := clientOptions{
+		name: "batch_compute_node",
+		authorizer: func() (autorest.Authorizer, error) {
+			return GetBatchAuthorizerWithResource(resource)
+		},
+		responseInspector:     respondTrace(),
+		sendInspector:         sendInspectDataPlane(serviceBatch, batchAccountName(accountEndpoint)),
+		clientRequestIDHeader: batchClientRequestIDHeader,
 	}
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetBatchAuthorizerWithResource(resource)
+	return azc.batchComputeNodeClients.get(accountEndpoint+resource, opts, func(env azure.Environment) (*batch.ComputeNodeClient, *autorest.Client) {
+		client := batch.NewComputeNodeClient(azc.batchURI(accountEndpoint))
+		return &client, &client.Client
+	})
+}
 
-	if err != nil {
-		return nil, err
+// GetBatchDataAccountClientWithResource get data plane account client with
+// resource
+func (azc *AzureClients) GetBatchDataAccountClientWithResource(accountEndpoint string, resource string) (*batch.AccountClient, error) {
+	opts := clientOptions{
+		name: "batch_data_account",
+		authorizer: func() (autorest.Authorizer, error) {
+			return GetBatchAuthorizerWithResource(resource)
+		},
+		responseInspector:     respondTrace(),
+		sendInspector:         sendInspectDataPlane(serviceBatch, batchAccountName(accountEndpoint)),
+		clientRequestIDHeader: batchClientRequestIDHeader,
 	}
 
-	client := batch.NewComputeNodeClient("https://" + accountEndpoint)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.batchComputeNodeClient[accountEndpoint+resource] = &client
-	azc.batchComputeNodeClient[accountEndpoint+resource].Authorizer = auth
-	// azc.batchJobClients[accountEndpoint+resource].ResponseInspector = respondInspectDebug()
-
-	return azc.batchComputeNodeClient[accountEndpoint+resource], nil
+	return azc.batchDataAccountClients.get(accountEndpoint+resource, opts, func(env azure.Environment) (*batch.AccountClient, *autorest.Client) {
+		client := batch.NewAccountClient(azc.batchURI(accountEndpoint))
+		return &client, &client.Client
+	})
 }
 
-// GetApplicationsClient get applications client
-func (azc *AzureClients) GetApplicationsClient(tenantID string) (*graph.ApplicationsClient, error) {
-	if _, ok := azc.applicationsClients[tenantID]; ok {
-		return azc.applicationsClients[tenantID], nil
+// GetResourceGraphClient get resource graph client
+func (azc *AzureClients) GetResourceGraphClient() (*resourcegraph.BaseClient, error) {
+	opts := clientOptions{
+		name:              "resource_graph",
+		authorizer:        GetAuthorizer,
+		responseInspector: respondTrace(),
 	}
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetGraphAuthorizer()
+	return azc.resourceGraphClients.get("", opts, func(env azure.Environment) (*resourcegraph.BaseClient, *autorest.Client) {
+		client := resourcegraph.NewWithBaseURI(azc.resourceManagerURI(env))
+		return &client, &client.Client
+	})
+}
 
-	if err != nil {
-		return nil, err
+// GetApplicationsClient get applications client
+func (azc *AzureClients) GetApplicationsClient(tenantID string) (*graph.ApplicationsClient, error) {
+	opts := clientOptions{
+		name:              "applications",
+		authorizer:        GetGraphAuthorizer,
+		responseInspector: respondTrace(),
 	}
 
-	client := graph.NewApplicationsClient(tenantID)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.applicationsClients[tenantID] = &client
-	azc.applicationsClients[tenantID].Authorizer = auth
-	// azc.applicationsClients[tenantID].ResponseInspector = respondInspectDebug()
-
-	return azc.applicationsClients[tenantID], nil
+	return azc.applicationsClients.get(tenantID, opts, func(env azure.Environment) (*graph.ApplicationsClient, *autorest.Client) {
+		client := graph.NewApplicationsClientWithBaseURI(azc.graphURI(env), tenantID)
+		return &client, &client.Client
+	})
 }
 
 // GetStorageAccountsClient get storage account client
 func (azc *AzureClients) GetStorageAccountsClient(subscriptionID string) (*storage.AccountsClient, error) {
-	if _, ok := azc.storageAccountsClients[subscriptionID]; ok {
-		return azc.storageAccountsClients[subscriptionID], nil
-	}
-
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetStorageAuthorizer()
-
-	if err != nil {
-		return nil, err
+	opts := clientOptions{
+		name:              "storage_accounts",
+		authorizer:        GetStorageAuthorizer,
+		responseInspector: respondInspect(subscriptionID),
 	}
 
-	client := storage.NewAccountsClient(subscriptionID)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.storageAccountsClients[subscriptionID] = &client
-	azc.storageAccountsClients[subscriptionID].Authorizer = auth
-	azc.storageAccountsClients[subscriptionID].ResponseInspector = respondInspect(subscriptionID)
-
-	return azc.storageAccountsClients[subscriptionID], nil
+	return azc.storageAccountsClients.get(subscriptionID, opts, func(env azure.Environment) (*storage.AccountsClient, *autorest.Client) {
+		client := storage.NewAccountsClientWithBaseURI(azc.resourceManagerURI(env), subscriptionID)
+		return &client, &client.Client
+	})
 }
 
 // GetStorageAccountsClientWithResource get storage account client
 func (azc *AzureClients) GetStorageAccountsClientWithResource(subscriptionID string, accountEndpoint string, resource string) (*storage.AccountsClient, error) {
-	if _, ok := azc.storageAccountsClients[accountEndpoint+resource]; ok {
-		return azc.storageAccountsClients[accountEndpoint+resource], nil
-	}
-
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetStorageAuthorizerWithResource(resource)
-
-	if err != nil {
-		return nil, err
+	opts := clientOptions{
+		name: "storage_accounts",
+		authorizer: func() (autorest.Authorizer, error) {
+			return GetStorageAuthorizerWithResource(resource)
+		},
+		responseInspector: respondInspect(subscriptionID),
 	}
 
-	client := storage.NewAccountsClientWithBaseURI(accountEndpoint, subscriptionID)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.storageAccountsClients[accountEndpoint+resource] = &client
-	azc.storageAccountsClients[accountEndpoint+resource].Authorizer = auth
-	azc.storageAccountsClients[accountEndpoint+resource].ResponseInspector = respondInspect(subscriptionID)
-
-	return azc.storageAccountsClients[accountEndpoint+resource], nil
+	return azc.storageAccountsClients.get(accountEndpoint+resource, opts, func(env azure.Environment) (*storage.AccountsClient, *autorest.Client) {
+		client := storage.NewAccountsClientWithBaseURI(accountEndpoint, subscriptionID)
+		return &client, &client.Client
+	})
 }
 
 // GetStorageAccountUsagesClient get storage account client
 func (azc *AzureClients) GetStorageAccountUsagesClient(subscriptionID string) (*storage.UsagesClient, error) {
-	if _, ok := azc.storageAccountUsagesClients[subscriptionID]; ok {
-		return azc.storageAccountUsagesClients[subscriptionID], nil
-	}
-
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetStorageAuthorizer()
-
-	if err != nil {
-		return nil, err
+	opts := clientOptions{
+		name:              "storage_account_usages",
+		authorizer:        GetStorageAuthorizer,
+		responseInspector: respondInspect(subscriptionID),
 	}
 
-	client := storage.NewUsagesClient(subscriptionID)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.storageAccountUsagesClients[subscriptionID] = &client
-	azc.storageAccountUsagesClients[subscriptionID].Authorizer = auth
-	azc.storageAccountUsagesClients[subscriptionID].ResponseInspector = respondInspect(subscriptionID)
-
-	return azc.storageAccountUsagesClients[subscriptionID], nil
+	return azc.storageAccountUsagesClients.get(subscriptionID, opts, func(env azure.Environment) (*storage.UsagesClient, *autorest.Client) {
+		client := storage.NewUsagesClientWithBaseURI(azc.resourceManagerURI(env), subscriptionID)
+		return &client, &client.Client
+	})
 }
 
 // GetBlobContainersClient get storage account client
 func (azc *AzureClients) GetBlobContainersClient(subscriptionID string) (*storage.BlobContainersClient, error) {
-	if _, ok := azc.blobContainersClients[subscriptionID]; ok {
-		return azc.blobContainersClients[subscriptionID], nil
+	opts := clientOptions{
+		name:              "blob_containers",
+		authorizer:        GetStorageAuthorizer,
+		responseInspector: respondInspect(subscriptionID),
 	}
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetStorageAuthorizer()
-
-	if err != nil {
-		return nil, err
-	}
-
-	client := storage.NewBlobContainersClient(subscriptionID)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.blobContainersClients[subscriptionID] = &client
-	azc.blobContainersClients[subscriptionID].Authorizer = auth
-	azc.blobContainersClients[subscriptionID].ResponseInspector = respondInspect(subscriptionID)
-
-	return azc.blobContainersClients[subscriptionID], nil
+	return azc.blobContainersClients.get(subscriptionID, opts, func(env azure.Environment) (*storage.BlobContainersClient, *autorest.Client) {
+		client := storage.NewBlobContainersClientWithBaseURI(azc.resourceManagerURI(env), subscriptionID)
+		return &client, &client.Client
+	})
 }
 
 // GetBlobContainersClientWithResource get storage account client
 func (azc *AzureClients) GetBlobContainersClientWithResource(subscriptionID string, accountEndpoint string, resource string) (*storage.BlobContainersClient, error) {
-	if _, ok := azc.blobContainersClients[accountEndpoint+resource]; ok {
-		return azc.blobContainersClients[accountEndpoint+resource], nil
+	opts := clientOptions{
+		name: "blob_containers",
+		authorizer: func() (autorest.Authorizer, error) {
+			return GetStorageAuthorizerWithResource(resource)
+		},
+		responseInspector: respondInspect(subscriptionID),
 	}
 
-	azc.mutex.Lock()
-	defer azc.mutex.Unlock()
-
-	auth, err := GetStorageAuthorizerWithResource(resource)
-
-	if err != nil {
-		return nil, err
-	}
-
-	client := storage.NewBlobContainersClientWithBaseURI(accountEndpoint, subscriptionID)
-	client.RetryAttempts = 3
-	client.RetryDuration = 3 * time.Second
-	azc.blobContainersClients[accountEndpoint+resource] = &client
-	azc.blobContainersClients[accountEndpoint+resource].Authorizer = auth
-	azc.blobContainersClients[accountEndpoint+resource].ResponseInspector = respondInspect(subscriptionID)
-
-	return azc.blobContainersClients[accountEndpoint+resource], nil
+	return azc.blobContainersClients.get(accountEndpoint+resource, opts, func(env azure.Environment) (*storage.BlobContainersClient, *autorest.Client) {
+		client := storage.NewBlobContainersClientWithBaseURI(accountEndpoint, subscriptionID)
+		return &client, &client.Client
+	})
 }
 
 // ----------------------------------------------------------------------------
@@ -407,8 +393,23 @@ func (azc *AzureClients) GetBlobContainersClientWithResource(subscriptionID stri
 func respondInspect(subscription string) autorest.RespondDecorator {
 	return func(r autorest.Responder) autorest.Responder {
 		return autorest.ResponderFunc(func(resp *http.Response) error {
+			traceResponse(resp)
+			recordAPICallStatus(resp)
 			SetReadRateLimitRemaining(os.Getenv("AZURE_TENANT_ID"), subscription, resp)
 			SetWriteRateLimitRemaining(os.Getenv("AZURE_TENANT_ID"), subscription, resp)
+			respondUnauthorized(resp)
+
+			return r.Respond(resp)
+		})
+	}
+}
+
+func respondTrace() autorest.RespondDecorator {
+	return func(r autorest.Responder) autorest.Responder {
+		return autorest.ResponderFunc(func(resp *http.Response) error {
+			traceResponse(resp)
+			recordAPICallStatus(resp)
+			respondUnauthorized(resp)
 			return r.Respond(resp)
 		})
 	}
@@ -0,0 +1,99 @@
+package azure
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+func TestAzureClientsConcurrentGetters(t *testing.T) {
+	setFakeCredential(t)
+
+	azc := NewAzureClients()
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			subscriptionID := fmt.Sprintf("subscription-%d", i%5)
+			accountEndpoint := fmt.Sprintf("account-%d.westeurope.batch.azure.com", i%5)
+
+			if _, err := azc.GetBatchPoolClient(subscriptionID); err != nil {
+				t.Error(err)
+			}
+
+			if _, err := azc.GetBatchJobClientWithResource(accountEndpoint, "https://batch.core.windows.net/"); err != nil {
+				t.Error(err)
+			}
+
+			if _, err := azc.GetBatchComputeNodeClient(accountEndpoint); err != nil {
+				t.Error(err)
+			}
+
+			if _, err := azc.GetStorageAccountsClient(subscriptionID); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for i := 0; i < 5; i++ {
+		subscriptionID := fmt.Sprintf("subscription-%d", i)
+
+		a, _ := azc.GetBatchPoolClient(subscriptionID)
+		b, _ := azc.GetBatchPoolClient(subscriptionID)
+
+		if a != b {
+			t.Errorf("%s: expected the same batch pool client", subscriptionID)
+		}
+	}
+
+	if got := len(azc.batchPoolClients.clients); got != 5 {
+		t.Errorf("expected 5 batch pool clients, got %d", got)
+	}
+
+	if got := len(azc.batchJobClients.clients); got != 5 {
+		t.Errorf("expected 5 batch job clients, got %d", got)
+	}
+}
+
+func TestAzureClientsEndpoints(t *testing.T) {
+	setFakeCredential(t)
+
+	httpClient := &http.Client{}
+	azc := NewAzureClientsWithHTTPClient(httpClient, config.EndpointsConfig{
+		ResourceManager: "http://127.0.0.1:8080/",
+		Batch:           "http://127.0.0.1:8081/{account}",
+	})
+
+	pools, err := azc.GetBatchPoolClient("subscription")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pools.BaseURI != "http://127.0.0.1:8080" {
+		t.Errorf("unexpected resource manager base uri %s", pools.BaseURI)
+	}
+
+	if pools.Sender != httpClient {
+		t.Error("expected the batch pool client to use the given http client")
+	}
+
+	jobs, err := azc.GetBatchJobClient("account.westeurope.batch.azure.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if jobs.BatchURL != "http://127.0.0.1:8081/account" {
+		t.Errorf("unexpected batch url %s", jobs.BatchURL)
+	}
+}
@@ -0,0 +1,164 @@
+package azure
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+var (
+	// Mutex used to lock read/writes of the current config.
+	configMutex = sync.RWMutex{}
+	// Config httpClient has been built from.
+	currentConfig config.AzureConfig
+	// Http client shared by all the Azure clients.
+	httpClient *http.Client
+	// Retry policy of the Azure clients.
+	retry = newRetryPolicy(config.RetryConfig{})
+)
+
+// ApplyConfig builds the http client and sets the endpoints and the retry
+// policy used by the AzureClients made afterwards, as well as the throttling
+// of the update metrics functions.
+func ApplyConfig(conf config.AzureConfig) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	if httpClient != nil && reflect.DeepEqual(conf, currentConfig) {
+		return nil
+	}
+
+	client, err := NewHTTPClient(conf.HTTPClient)
+
+	if err != nil {
+		return err
+	}
+
+	currentConfig = conf
+	httpClient = client
+	retry = newRetryPolicy(conf.Retry)
+	apiThrottler.setConfig(conf.Throttling)
+
+	return nil
+}
+
+// currentHTTPClient returns the http client of the current config.
+func currentHTTPClient() *http.Client {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	if httpClient == nil {
+		return http.DefaultClient
+	}
+
+	return httpClient
+}
+
+// currentRetryPolicy returns the retry policy of the current config.
+func currentRetryPolicy() retryPolicy {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return retry
+}
+
+// currentInventoryConfig returns the inventory config of the current config.
+func currentInventoryConfig() config.InventoryConfig {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return currentConfig.Inventory
+}
+
+// currentEndpoints returns the endpoints overrides of the current config.
+func currentEndpoints() config.EndpointsConfig {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return currentConfig.Endpoints
+}
+
+// NewHTTPClient makes an http client from conf. The proxy is taken from the
+// environment (HTTPS_PROXY, NO_PROXY) unless a proxy url is configured.
+func NewHTTPClient(conf config.HTTPClientConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if len(conf.ProxyURL) > 0 {
+		proxyURL, err := url.Parse(conf.ProxyURL)
+
+		if err != nil {
+			return nil, fmt.Errorf("azure: invalid proxy url: %v", err)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if len(conf.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("azure: failed to read CA file: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("azure: no certificate found in CA file %s", conf.CAFile)
+		}
+
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	if conf.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   conf.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+
+	if conf.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = conf.TLSHandshakeTimeout
+	}
+
+	if conf.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = conf.IdleConnTimeout
+	}
+
+	if conf.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	}
+
+	transport.DisableKeepAlives = conf.DisableKeepAlives
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   conf.Timeout,
+	}, nil
+}
+
+// overrideEndpoint returns endpoint with `{account}` replaced by account,
+// or def if endpoint is empty.
+func overrideEndpoint(endpoint string, account string, def string) string {
+	if len(endpoint) == 0 {
+		return def
+	}
+
+	return strings.TrimSuffix(strings.ReplaceAll(endpoint, "{account}", account), "/")
+}
@@ -0,0 +1,61 @@
+package azure
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	client, err := NewHTTPClient(config.HTTPClientConfig{
+		ProxyURL:            "http://proxy.example.com:3128",
+		Timeout:             time.Minute,
+		MaxIdleConnsPerHost: 42,
+		DisableKeepAlives:   true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := client.Transport.(*http.Transport)
+	proxy, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "management.azure.com"}})
+
+	if err != nil || proxy == nil || proxy.Host != "proxy.example.com:3128" {
+		t.Errorf("unexpected proxy %v: %v", proxy, err)
+	}
+
+	if client.Timeout != time.Minute || transport.MaxIdleConnsPerHost != 42 || !transport.DisableKeepAlives {
+		t.Errorf("http client not configured: %+v", transport)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ioutil.WriteFile(caFile, []byte("not a certificate"), 0600)
+
+	if _, err := NewHTTPClient(config.HTTPClientConfig{CAFile: caFile}); err == nil {
+		t.Error("expected an error with a CA file without certificate")
+	}
+}
+
+func TestOverrideEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		account  string
+		want     string
+	}{
+		{"", "account", "https://account.blob.core.windows.net"},
+		{"http://127.0.0.1:8080/", "account", "http://127.0.0.1:8080"},
+		{"http://{account}.blob.local:8080", "account", "http://account.blob.local:8080"},
+	}
+
+	for _, test := range tests {
+		if got := overrideEndpoint(test.endpoint, test.account, "https://account.blob.core.windows.net"); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.endpoint, got, test.want)
+		}
+	}
+}
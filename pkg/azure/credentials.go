@@ -0,0 +1,366 @@
+package azure
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/pkcs12"
+)
+
+const (
+	// CredentialClientSecret authenticates a service principal with AZURE_CLIENT_SECRET.
+	CredentialClientSecret = "client_secret"
+	// CredentialCertificate authenticates a service principal with the PKCS#12
+	// certificate found at AZURE_CERTIFICATE_PATH.
+	CredentialCertificate = "certificate"
+	// CredentialManagedIdentity authenticates with the managed identity of the
+	// host, AZURE_CLIENT_ID selects a user assigned identity.
+	CredentialManagedIdentity = "managed_identity"
+	// CredentialWorkloadIdentity authenticates with the Kubernetes service
+	// account token found at AZURE_FEDERATED_TOKEN_FILE.
+	CredentialWorkloadIdentity = "workload_identity"
+	// CredentialAzureCLI authenticates with the account logged in the Azure CLI.
+	CredentialAzureCLI = "azure_cli"
+)
+
+const (
+	// Client id of the Azure CLI application.
+	azureCLIClientID = "04b07795-8ddb-461a-bbee-02f9e1bf7b46"
+)
+
+var (
+	// Environment variables the credential is built from.
+	credentialEnvironmentVariables = []string{
+		"AZURE_ENVIRONMENT",
+		"AZURE_CREDENTIAL",
+		"AZURE_TENANT_ID",
+		"AZURE_CLIENT_ID",
+		"AZURE_CLIENT_SECRET",
+		"AZURE_CERTIFICATE_PATH",
+		"AZURE_CERTIFICATE_PASSWORD",
+		"AZURE_FEDERATED_TOKEN_FILE",
+		"AZURE_AUTHORITY_HOST",
+	}
+)
+
+var (
+	// Mutex used to lock read/writes of credential.
+	credentialMutex = sync.Mutex{}
+	// Credential built from the environment.
+	credential Credential
+)
+
+var (
+	// AzureAPICredentialSecretExpireTime Gauge of the expiration time of the credential secrets
+	AzureAPICredentialSecretExpireTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure_api",
+			Subsystem: "credential",
+			Name:      "secret_expire_time",
+			Help:      "Unix timestamp of the expiration of the client secrets or certificate of the exporter credential",
+		},
+		[]string{"credential", "key"},
+	)
+)
+
+func init() {
+	Registry.MustRegister(AzureAPICredentialSecretExpireTime)
+}
+
+// Credential provides the tokens used to authenticate against Azure.
+type Credential interface {
+	// Name returns the name of the credential backend.
+	Name() string
+	// Token returns a new auto refreshing token for the given resource.
+	Token(resource string) (*adal.ServicePrincipalToken, error)
+}
+
+// GetCredential returns the credential configured in the environment, it is
+// built once and shared by all the authorizers and tokens.
+func GetCredential() (Credential, error) {
+	credentialMutex.Lock()
+	defer credentialMutex.Unlock()
+
+	if credential != nil {
+		return credential, nil
+	}
+
+	c, err := NewCredentialFromEnvironment()
+
+	if err != nil {
+		return nil, err
+	}
+
+	credential = c
+
+	return credential, nil
+}
+
+// ResetCredential drops the credential, it is built again from the
+// environment on next use.
+func ResetCredential() {
+	credentialMutex.Lock()
+	credential = nil
+	credentialMutex.Unlock()
+}
+
+// CredentialEnvironment returns the values of the environment variables the
+// credential is built from, by name.
+func CredentialEnvironment() map[string]string {
+	env := make(map[string]string, len(credentialEnvironmentVariables))
+
+	for _, name := range credentialEnvironmentVariables {
+		env[name] = os.Getenv(name)
+	}
+
+	return env
+}
+
+// NewCredentialFromEnvironment builds the credential backend named by
+// AZURE_CREDENTIAL. If it is not set, the backend is guessed from the
+// variables which are set, falling back to managed identity.
+func NewCredentialFromEnvironment() (Credential, error) {
+	env, err := GetEnvironment()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	name := os.Getenv("AZURE_CREDENTIAL")
+
+	if len(name) == 0 {
+		switch {
+		case len(os.Getenv("AZURE_FEDERATED_TOKEN_FILE")) > 0:
+			name = CredentialWorkloadIdentity
+		case len(os.Getenv("AZURE_CLIENT_SECRET")) > 0:
+			name = CredentialClientSecret
+		case len(os.Getenv("AZURE_CERTIFICATE_PATH")) > 0:
+			name = CredentialCertificate
+		default:
+			name = CredentialManagedIdentity
+		}
+	}
+
+	switch name {
+	case CredentialClientSecret:
+		return &clientSecretCredential{
+			env:      env,
+			tenantID: tenantID,
+			clientID: clientID,
+			secret:   os.Getenv("AZURE_CLIENT_SECRET"),
+		}, nil
+	case CredentialCertificate:
+		return &certificateCredential{
+			env:      env,
+			tenantID: tenantID,
+			clientID: clientID,
+			path:     os.Getenv("AZURE_CERTIFICATE_PATH"),
+			password: os.Getenv("AZURE_CERTIFICATE_PASSWORD"),
+		}, nil
+	case CredentialManagedIdentity:
+		return &managedIdentityCredential{
+			clientID: clientID,
+		}, nil
+	case CredentialWorkloadIdentity:
+		return &workloadIdentityCredential{
+			env:           env,
+			authorityHost: os.Getenv("AZURE_AUTHORITY_HOST"),
+			tenantID:      tenantID,
+			clientID:      clientID,
+			tokenFile:     os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
+		}, nil
+	case CredentialAzureCLI:
+		return &azureCLICredential{
+			env:      env,
+			tenantID: tenantID,
+		}, nil
+	default:
+		return nil, fmt.Errorf("azure: unknown credential `%s`", name)
+	}
+}
+
+// clientSecretCredential authenticates a service principal with a client
+// secret.
+type clientSecretCredential struct {
+	env      azure.Environment
+	tenantID string
+	clientID string
+	secret   string
+}
+
+func (c *clientSecretCredential) Name() string {
+	return CredentialClientSecret
+}
+
+func (c *clientSecretCredential) Token(resource string) (*adal.ServicePrincipalToken, error) {
+	oauthConfig, err := adal.NewOAuthConfig(c.env.ActiveDirectoryEndpoint, c.tenantID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return adal.NewServicePrincipalToken(*oauthConfig, c.clientID, c.secret, resource)
+}
+
+// certificateCredential authenticates a service principal with a PKCS#12
+// certificate, the expiration of which is exported.
+type certificateCredential struct {
+	env      azure.Environment
+	tenantID string
+	clientID string
+	path     string
+	password string
+}
+
+func (c *certificateCredential) Name() string {
+	return CredentialCertificate
+}
+
+func (c *certificateCredential) Token(resource string) (*adal.ServicePrincipalToken, error) {
+	data, err := ioutil.ReadFile(c.path)
+
+	if err != nil {
+		return nil, fmt.Errorf("azure: failed to read certificate: %v", err)
+	}
+
+	privateKey, certificate, err := pkcs12.Decode(data, c.password)
+
+	if err != nil {
+		return nil, fmt.Errorf("azure: failed to decode certificate: %v", err)
+	}
+
+	thumbprint := fmt.Sprintf("%X", sha1.Sum(certificate.Raw))
+	AzureAPICredentialSecretExpireTime.WithLabelValues(CredentialCertificate, thumbprint).Set(float64(certificate.NotAfter.Unix()))
+
+	rsaPrivateKey, ok := privateKey.(*rsa.PrivateKey)
+
+	if !ok {
+		return nil, errors.New("azure: certificate private key is not a RSA key")
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(c.env.ActiveDirectoryEndpoint, c.tenantID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, c.clientID, certificate, rsaPrivateKey, resource)
+}
+
+// managedIdentityCredential authenticates with the managed identity of the
+// host, the user assigned one of clientID when it is set.
+type managedIdentityCredential struct {
+	clientID string
+}
+
+func (c *managedIdentityCredential) Name() string {
+	return CredentialManagedIdentity
+}
+
+func (c *managedIdentityCredential) Token(resource string) (*adal.ServicePrincipalToken, error) {
+	return adal.NewServicePrincipalTokenFromManagedIdentity(resource, &adal.ManagedIdentityOptions{
+		ClientID: c.clientID,
+	})
+}
+
+// workloadIdentityCredential exchanges the Kubernetes service account token
+// found in tokenFile for Azure tokens.
+type workloadIdentityCredential struct {
+	env           azure.Environment
+	authorityHost string
+	tenantID      string
+	clientID      string
+	tokenFile     string
+}
+
+func (c *workloadIdentityCredential) Name() string {
+	return CredentialWorkloadIdentity
+}
+
+func (c *workloadIdentityCredential) Token(resource string) (*adal.ServicePrincipalToken, error) {
+	authorityHost := c.authorityHost
+	if len(authorityHost) == 0 {
+		authorityHost = c.env.ActiveDirectoryEndpoint
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(authorityHost, c.tenantID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The projected service account token is rotated by the kubelet so it is
+	// read again on each refresh.
+	jwt := func() (string, error) {
+		b, err := ioutil.ReadFile(c.tokenFile)
+
+		if err != nil {
+			return "", fmt.Errorf("azure: failed to read federated token: %v", err)
+		}
+
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	return adal.NewServicePrincipalTokenFromFederatedTokenCallback(*oauthConfig, c.clientID, jwt, resource)
+}
+
+// azureCLICredential gets its tokens from the account logged in the Azure CLI.
+type azureCLICredential struct {
+	env      azure.Environment
+	tenantID string
+}
+
+func (c *azureCLICredential) Name() string {
+	return CredentialAzureCLI
+}
+
+func (c *azureCLICredential) Token(resource string) (*adal.ServicePrincipalToken, error) {
+	tenantID := c.tenantID
+	if len(tenantID) == 0 {
+		tenantID = "common"
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(c.env.ActiveDirectoryEndpoint, tenantID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := adal.NewServicePrincipalTokenWithSecret(*oauthConfig, azureCLIClientID, resource, &adal.ServicePrincipalNoSecret{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens are obtained by calling `az account get-access-token`.
+	token.SetCustomRefreshFunc(func(ctx context.Context, resource string) (*adal.Token, error) {
+		cliToken, err := cli.GetTokenFromCLI(resource)
+
+		if err != nil {
+			return nil, err
+		}
+
+		adalToken, err := cliToken.ToADALToken()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &adalToken, nil
+	})
+
+	return token, nil
+}
@@ -0,0 +1,85 @@
+package azure
+
+import (
+	"testing"
+)
+
+func TestNewCredentialFromEnvironment(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "managed identity by default",
+			env:  map[string]string{},
+			want: CredentialManagedIdentity,
+		},
+		{
+			name: "user assigned managed identity",
+			env:  map[string]string{"AZURE_CLIENT_ID": "client"},
+			want: CredentialManagedIdentity,
+		},
+		{
+			name: "certificate",
+			env:  map[string]string{"AZURE_CERTIFICATE_PATH": "/cert.p12"},
+			want: CredentialCertificate,
+		},
+		{
+			name: "secret over certificate",
+			env:  map[string]string{"AZURE_CLIENT_SECRET": "secret", "AZURE_CERTIFICATE_PATH": "/cert.p12"},
+			want: CredentialClientSecret,
+		},
+		{
+			name: "workload identity over secret",
+			env:  map[string]string{"AZURE_FEDERATED_TOKEN_FILE": "/token", "AZURE_CLIENT_SECRET": "secret", "AZURE_CERTIFICATE_PATH": "/cert.p12"},
+			want: CredentialWorkloadIdentity,
+		},
+		{
+			name: "override",
+			env:  map[string]string{"AZURE_CREDENTIAL": CredentialAzureCLI, "AZURE_FEDERATED_TOKEN_FILE": "/token", "AZURE_CLIENT_SECRET": "secret"},
+			want: CredentialAzureCLI,
+		},
+		{
+			name: "override with managed identity",
+			env:  map[string]string{"AZURE_CREDENTIAL": CredentialManagedIdentity, "AZURE_CLIENT_SECRET": "secret"},
+			want: CredentialManagedIdentity,
+		},
+		{
+			name:    "unknown credential",
+			env:     map[string]string{"AZURE_CREDENTIAL": "unknown"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"AZURE_CREDENTIAL", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_CERTIFICATE_PATH", "AZURE_FEDERATED_TOKEN_FILE", "AZURE_ENVIRONMENT"} {
+				t.Setenv(name, test.env[name])
+			}
+
+			credential, err := NewCredentialFromEnvironment()
+
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %s credential", credential.Name())
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := credential.Name(); got != test.want {
+				t.Errorf("credential = %s, want %s", got, test.want)
+			}
+
+			if c, ok := credential.(*managedIdentityCredential); ok && c.clientID != test.env["AZURE_CLIENT_ID"] {
+				t.Errorf("managed identity client id = %q, want %q", c.clientID, test.env["AZURE_CLIENT_ID"])
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package azure
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Prefix of the headers carrying the remaining calls of a rate limit.
+	rateLimitRemainingHeaderPrefix = "x-ms-ratelimit-remaining-"
+)
+
+var (
+	// AzureAPIDataPlaneThrottledCallsTotal Total number of throttled data plane calls
+	AzureAPIDataPlaneThrottledCallsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "azure_api",
+			Subsystem: "data_plane",
+			Name:      "throttled_calls_total",
+			Help:      "Total number of data plane calls throttled by the service, retries included",
+		},
+		[]string{"service", "account", "error_code"},
+	)
+
+	// AzureAPIDataPlaneRetryAfterSeconds Gauge of the last Retry-After of throttled data plane calls
+	AzureAPIDataPlaneRetryAfterSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure_api",
+			Subsystem: "data_plane",
+			Name:      "retry_after_seconds",
+			Help:      "Retry-After of the last throttled data plane call",
+		},
+		[]string{"service", "account"},
+	)
+
+	// AzureAPIDataPlaneRateLimitRemaining Gauge of the remaining calls reported by data plane services
+	AzureAPIDataPlaneRateLimitRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure_api",
+			Subsystem: "data_plane",
+			Name:      "rate_limit_remaining",
+			Help:      "Remaining calls reported by the x-ms-ratelimit-remaining-* headers of data plane responses",
+		},
+		[]string{"service", "account", "limit"},
+	)
+)
+
+func init() {
+	Registry.MustRegister(AzureAPIDataPlaneThrottledCallsTotal)
+	Registry.MustRegister(AzureAPIDataPlaneRetryAfterSeconds)
+	Registry.MustRegister(AzureAPIDataPlaneRateLimitRemaining)
+}
+
+// sendInspectDataPlane returns a SendDecorator inspecting the responses of the
+// data plane calls made for account. It is placed under the retry decorator
+// so that every attempt is inspected.
+func sendInspectDataPlane(service string, account string) autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			inspectDataPlaneResponse(service, account, resp)
+
+			return resp, err
+		})
+	}
+}
+
+// inspectDataPlaneResponse records the rate limit headers of resp and counts
+// it if it has been throttled.
+func inspectDataPlaneResponse(service string, account string, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+
+	for key, values := range resp.Header {
+		key = strings.ToLower(key)
+
+		if !strings.HasPrefix(key, rateLimitRemainingHeaderPrefix) || len(values) == 0 {
+			continue
+		}
+
+		if f, err := strconv.ParseFloat(values[0], 64); err == nil {
+			AzureAPIDataPlaneRateLimitRemaining.WithLabelValues(service, account, strings.TrimPrefix(key, rateLimitRemainingHeaderPrefix)).Set(f)
+		}
+	}
+
+	// Batch and storage signal throttling with 429 or 503 (ServerBusy).
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+
+	errorCode := resp.Header.Get("x-ms-error-code")
+	if len(errorCode) == 0 {
+		errorCode = strconv.Itoa(resp.StatusCode)
+	}
+
+	AzureAPIDataPlaneThrottledCallsTotal.WithLabelValues(service, account, errorCode).Inc()
+
+	if d, ok := retryAfter(resp); ok {
+		AzureAPIDataPlaneRetryAfterSeconds.WithLabelValues(service, account).Set(d.Seconds())
+	}
+}
@@ -0,0 +1,55 @@
+package azure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+func TestSendInspectDataPlane(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("x-ms-error-code", "ServerBusy")
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("x-ms-ratelimit-remaining-account-reads", "42")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	policy := newRetryPolicy(config.RetryConfig{Backoff: time.Millisecond})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := autorest.Send(req, sendInspectDataPlane(serviceBatch, "testaccount"), policy.sendDecorator())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if got := testutil.ToFloat64(AzureAPIDataPlaneThrottledCallsTotal.WithLabelValues(serviceBatch, "testaccount", "ServerBusy")); got != 1 {
+		t.Errorf("throttled_calls_total = %v, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(AzureAPIDataPlaneRetryAfterSeconds.WithLabelValues(serviceBatch, "testaccount")); got != 0 {
+		t.Errorf("retry_after_seconds = %v, want 0", got)
+	}
+
+	if got := testutil.ToFloat64(AzureAPIDataPlaneRateLimitRemaining.WithLabelValues(serviceBatch, "testaccount", "account-reads")); got != 42 {
+		t.Errorf("rate_limit_remaining = %v, want 42", got)
+	}
+}
@@ -0,0 +1,46 @@
+package azure
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// GetEnvironment returns the Azure environment named by AZURE_ENVIRONMENT,
+// public cloud by default.
+func GetEnvironment() (azure.Environment, error) {
+	envName := os.Getenv("AZURE_ENVIRONMENT")
+
+	if len(envName) == 0 {
+		envName = azure.PublicCloud.Name
+	}
+
+	return azure.EnvironmentFromName(envName)
+}
+
+// resourceManagerURI returns the base URI of the resource manager clients.
+func resourceManagerURI(env azure.Environment) string {
+	return strings.TrimSuffix(env.ResourceManagerEndpoint, "/")
+}
+
+// graphURI returns the base URI of the graph clients.
+func graphURI(env azure.Environment) string {
+	return strings.TrimSuffix(env.GraphEndpoint, "/")
+}
+
+// batchResource returns the token audience of the batch data plane.
+func batchResource(env azure.Environment) string {
+	return env.ResourceIdentifiers.Batch
+}
+
+// storageResource returns the token audience of the storage data plane.
+func storageResource(env azure.Environment) string {
+	return env.ResourceIdentifiers.Storage
+}
+
+// blobServiceURL returns the URL of the blob service of a storage account.
+func blobServiceURL(env azure.Environment, account string) string {
+	return fmt.Sprintf("https://%s.blob.%s", account, env.StorageEndpointSuffix)
+}
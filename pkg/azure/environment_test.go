@@ -0,0 +1,99 @@
+package azure
+
+import (
+	"testing"
+)
+
+func TestEnvironmentURLs(t *testing.T) {
+	tests := []struct {
+		environment     string
+		resourceManager string
+		graph           string
+		batch           string
+		storage         string
+		blob            string
+	}{
+		{
+			environment:     "",
+			resourceManager: "https://management.azure.com",
+			graph:           "https://graph.windows.net",
+			batch:           "https://batch.core.windows.net/",
+			storage:         "https://storage.azure.com/",
+			blob:            "https://account.blob.core.windows.net",
+		},
+		{
+			environment:     "AzurePublicCloud",
+			resourceManager: "https://management.azure.com",
+			graph:           "https://graph.windows.net",
+			batch:           "https://batch.core.windows.net/",
+			storage:         "https://storage.azure.com/",
+			blob:            "https://account.blob.core.windows.net",
+		},
+		{
+			environment:     "AzureChinaCloud",
+			resourceManager: "https://management.chinacloudapi.cn",
+			graph:           "https://graph.chinacloudapi.cn",
+			batch:           "https://batch.chinacloudapi.cn/",
+			storage:         "https://storage.azure.com/",
+			blob:            "https://account.blob.core.chinacloudapi.cn",
+		},
+		{
+			environment:     "AzureUSGovernmentCloud",
+			resourceManager: "https://management.usgovcloudapi.net",
+			graph:           "https://graph.windows.net",
+			batch:           "https://batch.core.usgovcloudapi.net/",
+			storage:         "https://storage.azure.com/",
+			blob:            "https://account.blob.core.usgovcloudapi.net",
+		},
+		{
+			environment:     "AzureGermanCloud",
+			resourceManager: "https://management.microsoftazure.de",
+			graph:           "https://graph.cloudapi.de",
+			batch:           "https://batch.cloudapi.de/",
+			storage:         "https://storage.azure.com/",
+			blob:            "https://account.blob.core.cloudapi.de",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.environment, func(t *testing.T) {
+			t.Setenv("AZURE_ENVIRONMENT", test.environment)
+
+			env, err := GetEnvironment()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{
+				"resource manager": resourceManagerURI(env),
+				"graph":            graphURI(env),
+				"batch":            batchResource(env),
+				"storage":          storageResource(env),
+				"blob":             blobServiceURL(env, "account"),
+			}
+
+			want := map[string]string{
+				"resource manager": test.resourceManager,
+				"graph":            test.graph,
+				"batch":            test.batch,
+				"storage":          test.storage,
+				"blob":             test.blob,
+			}
+
+			for k := range want {
+				if got[k] != want[k] {
+					t.Errorf("%s: got %q, want %q", k, got[k], want[k])
+				}
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		t.Setenv("AZURE_ENVIRONMENT", "AzureUnknownCloud")
+
+		if _, err := GetEnvironment(); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -20,7 +20,7 @@ var (
 			Name:      "calls_total",
 			Help:      "Total number of calls to the Azure Graph API",
 		},
-		[]string{},
+		[]string{"service", "operation", "status_code"},
 	)
 
 	// AzureAPIGraphCallsFailedTotal Total number of failed Azure Graph API calls
@@ -31,7 +31,7 @@ var (
 			Name:      "calls_failed_total",
 			Help:      "Total number of failed calls to the Azure Graph API",
 		},
-		[]string{},
+		[]string{"service", "operation", "status_code"},
 	)
 
 	// AzureAPIGraphCallsDurationSecondsBuckets Histograms of Azure Graph API calls durations in seconds
@@ -43,14 +43,14 @@ var (
 			Help:      "Histograms of successful Azure Graph API calls durations in seconds",
 			Buckets:   []float64{0.02, 0.03, 0.04, 0.05, 0.10, 0.20, 0.30, 0.40, 0.50, 0.75, 1.0, 2.0},
 		},
-		[]string{},
+		[]string{"service", "operation", "status_code"},
 	)
 )
 
 func init() {
-	prometheus.MustRegister(AzureAPIGraphCallsTotal)
-	prometheus.MustRegister(AzureAPIGraphCallsFailedTotal)
-	prometheus.MustRegister(AzureAPIGraphCallsDurationSecondsBuckets)
+	Registry.MustRegister(AzureAPIGraphCallsTotal)
+	Registry.MustRegister(AzureAPIGraphCallsFailedTotal)
+	Registry.MustRegister(AzureAPIGraphCallsDurationSecondsBuckets)
 }
 
 // ObserveAzureGraphAPICall ...
@@ -91,23 +91,71 @@ func ListApplications(ctx context.Context, clients *AzureClients) (*[]graph.Appl
 		return nil, err
 	}
 
-	t0 := time.Now()
-	apps, err := client.List(ctx, "")
-	t1 := time.Since(t0).Seconds()
+	var apps graph.ApplicationListResultPage
+
+	call := apiCall{
+		service:       serviceGraph,
+		client:        "graphrbac.ApplicationsClient",
+		operation:     "List",
+		observe:       ObserveAzureGraphAPICall,
+		observeFailed: ObserveAzureGraphAPICallFailed,
+	}
+
+	err = observeAPICall(ctx, call, func(ctx context.Context) (err error) {
+		apps, err = client.List(ctx, "")
+		return err
+	})
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-			ObserveAzureGraphAPICallFailed(t1)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-	ObserveAzureGraphAPICall(t1)
+	vals := make([]graph.Application, 0)
+
+	// The exporter application may be on any page.
+	for {
+		vals = append(vals, apps.Values()...)
+
+		more, err := fetchNextPage(ctx, call, &apps, apps.Response().OdataNextLink)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !more {
+			break
+		}
+	}
 
-	vals := apps.Values()
 	c.SetDefault(cacheKey, &vals)
 
 	return &vals, nil
 }
+
+// ObserveCredentialSecretExpiry sets the expiration time of the client secrets
+// of the exporter application if it is part of the given applications.
+func ObserveCredentialSecretExpiry(applications *[]graph.Application) {
+	cred, err := GetCredential()
+
+	if err != nil || cred.Name() != CredentialClientSecret {
+		return
+	}
+
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+
+	AzureAPICredentialSecretExpireTime.Reset()
+
+	for _, app := range *applications {
+		if app.AppID == nil || *app.AppID != clientID || app.PasswordCredentials == nil {
+			continue
+		}
+
+		for _, password := range *app.PasswordCredentials {
+			if password.KeyID == nil || password.EndDate == nil {
+				continue
+			}
+
+			AzureAPICredentialSecretExpireTime.WithLabelValues(CredentialClientSecret, *password.KeyID).Set(float64(password.EndDate.Unix()))
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	azurebatch "github.com/Azure/azure-sdk-for-go/services/batch/mgmt/2019-08-01/batch"
+	"github.com/Azure/azure-sdk-for-go/services/resourcegraph/mgmt/2019-04-01/resourcegraph"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
+	log "github.com/sirupsen/logrus"
+	"sylr.dev/libqd/cache"
+)
+
+const (
+	cacheKeyInventory = `inventory-%s`
+
+	// Resource types of the inventory as returned by Resource Graph.
+	resourceTypeBatchAccount   = "microsoft.batch/batchaccounts"
+	resourceTypeStorageAccount = "microsoft.storage/storageaccounts"
+
+	// Page size of the Resource Graph queries, 1000 is the maximum.
+	inventoryPageSize = 1000
+)
+
+// inventoryQuery lists the batch and storage accounts along with the fields
+// needed to decode them into their ARM models.
+var inventoryQuery = `Resources
+| where type =~ '` + resourceTypeBatchAccount + `' or type =~ '` + resourceTypeStorageAccount + `'
+| project id, name, type, location, kind, sku, identity, tags, properties, subscriptionId
+| order by id asc`
+
+// Inventory holds the batch and storage accounts of the subscriptions, by
+// lower cased subscription id.
+type Inventory struct {
+	Subscriptions   []string
+	BatchAccounts   map[string][]azurebatch.Account
+	StorageAccounts map[string][]storage.Account
+}
+
+// inventoryContextKey is the key of the inventory lookup of a run in a
+// context.
+type inventoryContextKey struct{}
+
+// inventoryLookup fetches the inventory of a run at most once.
+type inventoryLookup struct {
+	once      sync.Once
+	inventory *Inventory
+	err       error
+}
+
+// ContextWithInventory returns a copy of ctx in which the inventory is fetched
+// at most once, whatever the number of subscriptions and resource types
+// listed. It is meant to be called once per update metrics run so that the
+// inventory query is not repeated when caching is disabled.
+func ContextWithInventory(ctx context.Context) context.Context {
+	return context.WithValue(ctx, inventoryContextKey{}, &inventoryLookup{})
+}
+
+// inventoryFromContext returns the inventory of the run held by ctx, fetching
+// it on first use, or the one returned by GetInventory if there is no run.
+func inventoryFromContext(ctx context.Context, clients *AzureClients) (*Inventory, error) {
+	lookup, ok := ctx.Value(inventoryContextKey{}).(*inventoryLookup)
+
+	if !ok {
+		return GetInventory(ctx, clients)
+	}
+
+	lookup.once.Do(func() {
+		lookup.inventory, lookup.err = GetInventory(ctx, clients)
+	})
+
+	return lookup.inventory, lookup.err
+}
+
+// inventoryEnabled tells whether the accounts are listed with Resource Graph.
+func inventoryEnabled() bool {
+	return currentInventoryConfig().ResourceGraph
+}
+
+// inventorySubscriptions returns the sorted subscriptions of the inventory.
+func inventorySubscriptions() []string {
+	subscriptions := append([]string(nil), currentInventoryConfig().Subscriptions...)
+
+	if len(subscriptions) == 0 {
+		subscriptions = []string{os.Getenv("AZURE_SUBSCRIPTION_ID")}
+	}
+
+	sort.Strings(subscriptions)
+
+	return subscriptions
+}
+
+// GetInventory returns the batch and storage accounts of the configured
+// subscriptions, listed with one paginated Resource Graph query.
+func GetInventory(ctx context.Context, clients *AzureClients) (*Inventory, error) {
+	c := cache.GetCache(5*time.Minute, time.Minute)
+
+	subscriptions := inventorySubscriptions()
+	cacheKey := fmt.Sprintf(cacheKeyInventory, strings.ToLower(strings.Join(subscriptions, ",")))
+
+	contextLogger := log.WithFields(log.Fields{
+		"_id": ctx.Value("id"),
+	})
+
+	if cinventory, ok := c.Get(cacheKey); ok {
+		if inventory, ok := cinventory.(*Inventory); !ok {
+			contextLogger.Errorf("Failed to cast object from cache back to *Inventory")
+		} else {
+			return inventory, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	client, err := clients.GetResourceGraphClient()
+
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := &Inventory{
+		Subscriptions:   subscriptions,
+		BatchAccounts:   make(map[string][]azurebatch.Account),
+		StorageAccounts: make(map[string][]storage.Account),
+	}
+
+	request := resourcegraph.QueryRequest{
+		Subscriptions: &subscriptions,
+		Query:         &inventoryQuery,
+		Options: &resourcegraph.QueryRequestOptions{
+			Top:          toInt32Ptr(inventoryPageSize),
+			ResultFormat: resourcegraph.ResultFormatObjectArray,
+		},
+	}
+
+	for {
+		var response resourcegraph.QueryResponse
+
+		err = observeAPICall(ctx, apiCall{
+			service:   serviceResourceManager,
+			client:    "resourcegraph.BaseClient",
+			operation: "Resources",
+		}, func(ctx context.Context) (err error) {
+			response, err = client.Resources(ctx, request)
+			return err
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := inventory.add(response.Data); err != nil {
+			return nil, err
+		}
+
+		if response.SkipToken == nil || len(*response.SkipToken) == 0 {
+			break
+		}
+
+		request.Options.SkipToken = response.SkipToken
+	}
+
+	c.SetDefault(cacheKey, inventory)
+
+	return inventory, nil
+}
+
+// add decodes the rows of a Resource Graph response into the inventory.
+func (i *Inventory) add(data interface{}) error {
+	rows, ok := data.([]interface{})
+
+	if !ok {
+		return fmt.Errorf("azure: unexpected resource graph data type %T", data)
+	}
+
+	for _, row := range rows {
+		fields, ok := row.(map[string]interface{})
+
+		if !ok {
+			return fmt.Errorf("azure: unexpected resource graph row type %T", row)
+		}
+
+		resourceType, _ := fields["type"].(string)
+		subscriptionID, _ := fields["subscriptionId"].(string)
+		subscriptionID = strings.ToLower(subscriptionID)
+
+		// The rows have the same shape as the ARM payloads.
+		b, err := json.Marshal(fields)
+
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(resourceType) {
+		case resourceTypeBatchAccount:
+			account := azurebatch.Account{}
+
+			if err := json.Unmarshal(b, &account); err != nil {
+				return fmt.Errorf("azure: unable to decode batch account: %v", err)
+			}
+
+			i.BatchAccounts[subscriptionID] = append(i.BatchAccounts[subscriptionID], account)
+		case resourceTypeStorageAccount:
+			account := storage.Account{}
+
+			if err := json.Unmarshal(b, &account); err != nil {
+				return fmt.Errorf("azure: unable to decode storage account: %v", err)
+			}
+
+			i.StorageAccounts[subscriptionID] = append(i.StorageAccounts[subscriptionID], account)
+		}
+	}
+
+	return nil
+}
+
+// covers tells whether subscriptionID is one of the subscriptions queried.
+func (i *Inventory) covers(subscriptionID string) bool {
+	for _, s := range i.Subscriptions {
+		if strings.EqualFold(s, subscriptionID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inventoryBatchAccounts returns the batch accounts of subscriptionID from the
+// inventory.
+func inventoryBatchAccounts(ctx context.Context, clients *AzureClients, subscriptionID string) (*[]azurebatch.Account, error) {
+	inventory, err := inventoryFromContext(ctx, clients)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !inventory.covers(subscriptionID) {
+		return nil, fmt.Errorf("azure: subscription %s is not in the inventory", subscriptionID)
+	}
+
+	accounts := inventory.BatchAccounts[strings.ToLower(subscriptionID)]
+
+	return &accounts, nil
+}
+
+// inventoryStorageAccounts returns the storage accounts of subscriptionID from
+// the inventory.
+func inventoryStorageAccounts(ctx context.Context, clients *AzureClients, subscriptionID string) (*[]storage.Account, error) {
+	inventory, err := inventoryFromContext(ctx, clients)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !inventory.covers(subscriptionID) {
+		return nil, fmt.Errorf("azure: subscription %s is not in the inventory", subscriptionID)
+	}
+
+	accounts := inventory.StorageAccounts[strings.ToLower(subscriptionID)]
+
+	return &accounts, nil
+}
+
+func toInt32Ptr(i int32) *int32 {
+	return &i
+}
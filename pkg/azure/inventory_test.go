@@ -0,0 +1,258 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	azurebatch "github.com/Azure/azure-sdk-for-go/services/batch/mgmt/2019-08-01/batch"
+	"github.com/Azure/azure-sdk-for-go/services/preview/subscription/mgmt/2018-03-01-preview/subscription"
+	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+	"sylr.dev/libqd/cache"
+)
+
+// inventoryPayload is a Resource Graph response in the object array format.
+const inventoryPayload = `[
+	{
+		"id": "/subscriptions/AAAAAAAA-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Batch/batchAccounts/batch1",
+		"name": "batch1",
+		"type": "Microsoft.Batch/batchAccounts",
+		"location": "westeurope",
+		"subscriptionId": "AAAAAAAA-0000-0000-0000-000000000000",
+		"properties": {"accountEndpoint": "batch1.westeurope.batch.azure.com"}
+	},
+	{
+		"id": "/subscriptions/aaaaaaaa-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/storage1",
+		"name": "storage1",
+		"type": "microsoft.storage/storageaccounts",
+		"location": "westeurope",
+		"kind": "StorageV2",
+		"sku": {"name": "Standard_LRS"},
+		"subscriptionId": "aaaaaaaa-0000-0000-0000-000000000000",
+		"properties": {}
+	},
+	{
+		"id": "/subscriptions/bbbbbbbb-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Batch/batchAccounts/batch2",
+		"name": "batch2",
+		"type": "microsoft.batch/batchaccounts",
+		"location": "northeurope",
+		"subscriptionId": "BbBbBbBb-0000-0000-0000-000000000000",
+		"properties": {"accountEndpoint": "batch2.northeurope.batch.azure.com"}
+	},
+	{
+		"id": "/subscriptions/bbbbbbbb-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+		"name": "vm",
+		"type": "microsoft.compute/virtualmachines",
+		"subscriptionId": "bbbbbbbb-0000-0000-0000-000000000000"
+	}
+]`
+
+// setInventoryConfig replaces the inventory config for the duration of the
+// test.
+func setInventoryConfig(t *testing.T, conf config.InventoryConfig) {
+	configMutex.Lock()
+	previous := currentConfig.Inventory
+	currentConfig.Inventory = conf
+	configMutex.Unlock()
+
+	t.Cleanup(func() {
+		configMutex.Lock()
+		currentConfig.Inventory = previous
+		configMutex.Unlock()
+	})
+}
+
+func TestInventoryAdd(t *testing.T) {
+	var data interface{}
+
+	if err := json.Unmarshal([]byte(inventoryPayload), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := &Inventory{
+		Subscriptions:   []string{"AAAAAAAA-0000-0000-0000-000000000000", "bbbbbbbb-0000-0000-0000-000000000000"},
+		BatchAccounts:   make(map[string][]azurebatch.Account),
+		StorageAccounts: make(map[string][]storage.Account),
+	}
+
+	if err := inventory.add(data); err != nil {
+		t.Fatal(err)
+	}
+
+	batchAccounts := inventory.BatchAccounts["aaaaaaaa-0000-0000-0000-000000000000"]
+
+	if len(batchAccounts) != 1 || *batchAccounts[0].Name != "batch1" || *batchAccounts[0].AccountEndpoint != "batch1.westeurope.batch.azure.com" {
+		t.Errorf("unexpected batch accounts of subscription aaaaaaaa: %+v", batchAccounts)
+	}
+
+	batchAccounts = inventory.BatchAccounts["bbbbbbbb-0000-0000-0000-000000000000"]
+
+	if len(batchAccounts) != 1 || *batchAccounts[0].Name != "batch2" || *batchAccounts[0].Location != "northeurope" {
+		t.Errorf("unexpected batch accounts of subscription bbbbbbbb: %+v", batchAccounts)
+	}
+
+	storageAccounts := inventory.StorageAccounts["aaaaaaaa-0000-0000-0000-000000000000"]
+
+	if len(storageAccounts) != 1 || *storageAccounts[0].Name != "storage1" || storageAccounts[0].Kind != storage.StorageV2 {
+		t.Errorf("unexpected storage accounts of subscription aaaaaaaa: %+v", storageAccounts)
+	}
+
+	if got := len(inventory.BatchAccounts) + len(inventory.StorageAccounts); got != 3 {
+		t.Errorf("got accounts in %d subscriptions, want 3", got)
+	}
+
+	for subscriptionID, want := range map[string]bool{
+		"aaaaaaaa-0000-0000-0000-000000000000": true,
+		"BBBBBBBB-0000-0000-0000-000000000000": true,
+		"cccccccc-0000-0000-0000-000000000000": false,
+	} {
+		if got := inventory.covers(subscriptionID); got != want {
+			t.Errorf("covers(%s) = %v, want %v", subscriptionID, got, want)
+		}
+	}
+
+	if err := inventory.add(map[string]interface{}{}); err == nil {
+		t.Error("expected an error for a non object array payload")
+	}
+}
+
+// setNoCache disables the cache for the duration of the test so that it does
+// not depend on what other tests cached.
+func setNoCache(t *testing.T) {
+	cache.SetNoop(true)
+
+	t.Cleanup(func() {
+		cache.SetNoop(false)
+	})
+}
+
+// newInventoryServer returns a server answering the Resource Graph queries
+// with inventoryPayload and listing the batch account `arm` from resource
+// manager, it counts the requests of both.
+func newInventoryServer(t *testing.T, graphRequests *int32, armRequests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if strings.HasPrefix(r.URL.Path, "/providers/Microsoft.ResourceGraph/resources") {
+			atomic.AddInt32(graphRequests, 1)
+			fmt.Fprintf(w, `{"totalRecords":4,"count":4,"resultTruncated":"false","data":%s}`, inventoryPayload)
+			return
+		}
+
+		atomic.AddInt32(armRequests, 1)
+
+		if !strings.HasSuffix(r.URL.Path, "/providers/Microsoft.Batch/batchAccounts") {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+
+		fmt.Fprint(w, `{"value":[{"id":"/subscriptions/eeeeeeee-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Batch/batchAccounts/arm","name":"arm"}]}`)
+	}))
+}
+
+func TestInventoryOncePerRun(t *testing.T) {
+	setFakeCredential(t)
+	setNoCache(t)
+
+	setInventoryConfig(t, config.InventoryConfig{
+		ResourceGraph: true,
+		Subscriptions: []string{"aaaaaaaa-0000-0000-0000-000000000000", "bbbbbbbb-0000-0000-0000-000000000000"},
+	})
+
+	var graphRequests, armRequests int32
+
+	server := newInventoryServer(t, &graphRequests, &armRequests)
+	defer server.Close()
+
+	clients := NewAzureClientsWithHTTPClient(server.Client(), config.EndpointsConfig{
+		ResourceManager: server.URL,
+	})
+
+	ctx := ContextWithInventory(context.WithValue(context.Background(), "id", "0a1b2c3d"))
+
+	for _, subscriptionID := range []string{"AAAAAAAA-0000-0000-0000-000000000000", "bbbbbbbb-0000-0000-0000-000000000000"} {
+		sub := &subscription.Model{SubscriptionID: &subscriptionID, DisplayName: &subscriptionID}
+
+		accounts, err := ListSubscriptionBatchAccounts(ctx, clients, sub)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(*accounts) != 1 {
+			t.Errorf("%s: got %d batch accounts, want 1", subscriptionID, len(*accounts))
+		}
+
+		if _, err := ListSubscriptionStorageAccounts(ctx, clients, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&graphRequests); got != 1 {
+		t.Errorf("got %d resource graph requests, want 1", got)
+	}
+
+	if got := atomic.LoadInt32(&armRequests); got != 0 {
+		t.Errorf("got %d resource manager requests, want 0", got)
+	}
+}
+
+func TestListSubscriptionBatchAccountsInventoryFallback(t *testing.T) {
+	setFakeCredential(t)
+	setNoCache(t)
+
+	covered := "AAAAAAAA-0000-0000-0000-000000000000"
+	uncovered := "eeeeeeee-0000-0000-0000-000000000000"
+
+	setInventoryConfig(t, config.InventoryConfig{
+		ResourceGraph: true,
+		Subscriptions: []string{strings.ToLower(covered)},
+	})
+
+	var graphRequests, armRequests int32
+
+	server := newInventoryServer(t, &graphRequests, &armRequests)
+	defer server.Close()
+
+	clients := NewAzureClientsWithHTTPClient(server.Client(), config.EndpointsConfig{
+		ResourceManager: server.URL,
+	})
+
+	ctx := ContextWithInventory(context.WithValue(context.Background(), "id", "0a1b2c3d"))
+	accounts, err := ListSubscriptionBatchAccounts(ctx, clients, &subscription.Model{SubscriptionID: &covered, DisplayName: &covered})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*accounts) != 1 || *(*accounts)[0].Name != "batch1" {
+		t.Errorf("unexpected accounts of the covered subscription: %+v", *accounts)
+	}
+
+	if got := atomic.LoadInt32(&armRequests); got != 0 {
+		t.Errorf("got %d resource manager requests for the covered subscription, want 0", got)
+	}
+
+	accounts, err = ListSubscriptionBatchAccounts(ctx, clients, &subscription.Model{SubscriptionID: &uncovered, DisplayName: &uncovered})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*accounts) != 1 || *(*accounts)[0].Name != "arm" {
+		t.Errorf("unexpected accounts of the uncovered subscription: %+v", *accounts)
+	}
+
+	if got := atomic.LoadInt32(&armRequests); got != 1 {
+		t.Errorf("got %d resource manager requests for the uncovered subscription, want 1", got)
+	}
+
+	if got := atomic.LoadInt32(&graphRequests); got != 1 {
+		t.Errorf("got %d resource graph requests, want 1", got)
+	}
+}
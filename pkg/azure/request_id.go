@@ -0,0 +1,54 @@
+package azure
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Header carrying the client request id, echoed by Azure in its logs.
+	clientRequestIDHeader = "x-ms-client-request-id"
+	// Header of the client request id of the batch data plane.
+	batchClientRequestIDHeader = "client-request-id"
+	// Headers carrying the id Azure gave to the request.
+	requestIDHeader      = "x-ms-request-id"
+	batchRequestIDHeader = "request-id"
+)
+
+// newClientRequestID returns a new client request id for the run of ctx. It is
+// a random UUID whose first group is the run id, which is 8 hex digits long,
+// so that the requests of a run can be found from its logs.
+func newClientRequestID(ctx context.Context) string {
+	id := uuid.New()
+
+	if runID, ok := ctx.Value("id").(string); ok {
+		if b, err := hex.DecodeString(runID); err == nil && len(b) == 4 {
+			copy(id[:4], b)
+		}
+	}
+
+	return id.String()
+}
+
+// setClientRequestID sets a new client request id on r and records it on the
+// call r is made for. It replaces the random id set by azblob.
+func setClientRequestID(r *http.Request, header string) {
+	id := newClientRequestID(r.Context())
+	r.Header.Set(header, id)
+
+	if status := apiCallStatusFromContext(r.Context()); status != nil {
+		status.setClientRequestID(id)
+	}
+}
+
+// responseRequestID returns the id Azure gave to the request of resp.
+func responseRequestID(resp *http.Response) string {
+	if id := resp.Header.Get(requestIDHeader); len(id) > 0 {
+		return id
+	}
+
+	return resp.Header.Get(batchRequestIDHeader)
+}
@@ -0,0 +1,96 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestClientRequestID(t *testing.T) {
+	var received string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(clientRequestIDHeader)
+		w.Header().Set(requestIDHeader, "azure-request-id")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx := context.WithValue(context.Background(), "id", "0a1b2c3d")
+
+	var status *apiCallStatus
+
+	err := observeAPICall(ctx, apiCall{service: serviceResourceManager, client: "test.Client", operation: "Get"}, func(ctx context.Context) error {
+		status = apiCallStatusFromContext(ctx)
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		req, err := autorest.Prepare(req, requestInspect(""))
+
+		if err != nil {
+			return err
+		}
+
+		resp, err := autorest.Send(req)
+
+		if err != nil {
+			return err
+		}
+
+		recordAPICallStatus(resp)
+
+		return errors.New("failed")
+	})
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if !strings.HasPrefix(received, "0a1b2c3d-") || len(received) != 36 {
+		t.Errorf("client request id = %q, want a UUID starting with the run id", received)
+	}
+
+	fields := status.fields()
+
+	if fields["client_request_id"] != received {
+		t.Errorf("client_request_id = %v, want %v", fields["client_request_id"], received)
+	}
+
+	if fields["request_id"] != "azure-request-id" {
+		t.Errorf("request_id = %v, want azure-request-id", fields["request_id"])
+	}
+
+	if fields["status_code"] != http.StatusInternalServerError {
+		t.Errorf("status_code = %v, want %d", fields["status_code"], http.StatusInternalServerError)
+	}
+}
+
+func TestClientRequestIDNextPage(t *testing.T) {
+	received := make(chan *http.Request, 2)
+	server := newTestPagesServer(2, received)
+	defer server.Close()
+
+	ctx := context.WithValue(context.Background(), "id", "0a1b2c3d")
+	call := apiCall{service: serviceBatch, client: "batch.ComputeNodeClient", operation: "TestListNodes"}
+	first := server.URL + "/?page=0"
+	page := &testPager{header: batchClientRequestIDHeader, nextLink: &first}
+
+	if err := observeAPICall(ctx, call, page.NextWithContext); err != nil {
+		t.Fatal(err)
+	}
+
+	if more, err := fetchNextPage(ctx, call, page, page.nextLink); err != nil || !more {
+		t.Fatalf("fetchNextPage() = %v, %v, want true, nil", more, err)
+	}
+
+	<-received
+	second := (<-received).Header.Get(batchClientRequestIDHeader)
+
+	if !strings.HasPrefix(second, "0a1b2c3d-") || len(second) != 36 {
+		t.Errorf("client request id of the second page = %q, want a UUID starting with the run id", second)
+	}
+}
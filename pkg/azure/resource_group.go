@@ -37,19 +37,23 @@ func GetResourceGroup(ctx context.Context, clients *AzureClients, subscription *
 		return nil, err
 	}
 
-	t0 := time.Now()
-	group, err := client.Get(ctx, name)
-	t1 := time.Since(t0).Seconds()
+	var group resources.Group
+
+	err = observeAPICall(ctx, apiCall{
+		service:        serviceResourceManager,
+		client:         "resources.GroupsClient",
+		operation:      "Get",
+		subscriptionID: *subscription.SubscriptionID,
+		resourceGroup:  name,
+	}, func(ctx context.Context) (err error) {
+		group, err = client.Get(ctx, name)
+		return err
+	})
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-
 	c.SetDefault(cacheKey, &group)
 
 	return &group, nil
@@ -0,0 +1,205 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+const (
+	defaultRetryMaxAttempts = 4
+	defaultRetryBackoff     = 3 * time.Second
+	defaultRetryMaxBackoff  = time.Minute
+)
+
+var (
+	// AzureAPIRetriesTotal Total number of retried Azure API requests
+	AzureAPIRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "azure_api",
+			Subsystem: "",
+			Name:      "retries_total",
+			Help:      "Total number of Azure API requests retried by reason",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	Registry.MustRegister(AzureAPIRetriesTotal)
+}
+
+// retryPolicy retries requests on network timeouts, connection resets and
+// refusals, 429 and 5xx responses.
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+// newRetryPolicy makes a retryPolicy from conf, unset values are defaulted.
+func newRetryPolicy(conf config.RetryConfig) retryPolicy {
+	p := retryPolicy{
+		maxAttempts: conf.MaxAttempts,
+		backoff:     conf.Backoff,
+		maxBackoff:  conf.MaxBackoff,
+	}
+
+	if p.maxAttempts == 0 {
+		p.maxAttempts = defaultRetryMaxAttempts
+	}
+
+	if p.backoff == 0 {
+		p.backoff = defaultRetryBackoff
+	}
+
+	if p.maxBackoff == 0 {
+		p.maxBackoff = defaultRetryMaxBackoff
+	}
+
+	if p.backoff > p.maxBackoff {
+		p.backoff = p.maxBackoff
+	}
+
+	return p
+}
+
+// do calls send until it succeeds, fails with an error which is not worth
+// retrying or the attempts are exhausted. The response of the last attempt
+// is returned.
+func (p retryPolicy) do(ctx context.Context, send func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := send()
+		reason := retryReason(resp, err)
+
+		if len(reason) == 0 || attempt >= p.maxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+
+		delay := p.delay(attempt, resp)
+		autorest.DrainResponseBody(resp)
+		AzureAPIRetriesTotal.WithLabelValues(reason).Inc()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// delay returns how long to wait before the attempt following attempt. The
+// Retry-After header of 429 and 503 responses takes precedence over the
+// exponential backoff. Both are bounded by maxBackoff so that a long
+// Retry-After does not stall the caller.
+func (p retryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if d, ok := retryAfter(resp); ok {
+			if d > p.maxBackoff {
+				d = p.maxBackoff
+			}
+
+			return d
+		}
+	}
+
+	delay := p.backoff
+	for i := 1; i < attempt && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+
+	return delay
+}
+
+// sendDecorator returns the autorest.SendDecorator applying the policy. It
+// replaces the retry decorators of the generated clients.
+func (p retryPolicy) sendDecorator() autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			rr := autorest.NewRetriableRequest(r)
+
+			return p.do(r.Context(), func() (*http.Response, error) {
+				if err := rr.Prepare(); err != nil {
+					return nil, err
+				}
+
+				return s.Do(rr.Request())
+			})
+		})
+	}
+}
+
+// retryReason returns the reason why the outcome of a request is worth
+// retrying, or an empty string if it is not.
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		// The caller gave up, context errors are also timeouts.
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return ""
+		}
+
+		// *url.Error is a net.Error, only transient failures are retried, not
+		// TLS, proxy or DNS resolution errors.
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return "network"
+		}
+
+		if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+			return "network"
+		}
+
+		return ""
+	}
+
+	switch {
+	case resp == nil:
+		return ""
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return "throttled"
+	case resp.StatusCode == http.StatusServiceUnavailable:
+		return "unavailable"
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusGatewayTimeout:
+		return "timeout"
+	case resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented && resp.StatusCode != http.StatusHTTPVersionNotSupported:
+		return "server_error"
+	}
+
+	return ""
+}
+
+// retryAfter parses the Retry-After header of resp which is either a number
+// of seconds or an http date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d, true
+		}
+
+		return 0, true
+	}
+
+	return 0, false
+}
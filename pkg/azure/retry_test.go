@@ -0,0 +1,159 @@
+package azure
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := newRetryPolicy(config.RetryConfig{
+		Backoff:    time.Second,
+		MaxBackoff: 5 * time.Second,
+	})
+
+	if p.maxAttempts != defaultRetryMaxAttempts {
+		t.Errorf("maxAttempts = %d, want %d", p.maxAttempts, defaultRetryMaxAttempts)
+	}
+
+	tests := []struct {
+		attempt int
+		resp    *http.Response
+		want    time.Duration
+	}{
+		{1, nil, time.Second},
+		{2, nil, 2 * time.Second},
+		{3, nil, 4 * time.Second},
+		{4, nil, 5 * time.Second},
+		{1, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"3"}}}, 3 * time.Second},
+		{3, &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"1"}}}, time.Second},
+		{1, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"30"}}}, 5 * time.Second},
+		{1, &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"3600"}}}, 5 * time.Second},
+		{1, &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{"Retry-After": {"30"}}}, time.Second},
+		{2, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}, 2 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := p.delay(test.attempt, test.resp); got != test.want {
+			t.Errorf("delay(%d) = %v, want %v", test.attempt, got, test.want)
+		}
+	}
+}
+
+func TestRetryPolicySendDecorator(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	p := newRetryPolicy(config.RetryConfig{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+	})
+
+	throttled := testutil.ToFloat64(AzureAPIRetriesTotal.WithLabelValues("throttled"))
+	serverError := testutil.ToFloat64(AzureAPIRetriesTotal.WithLabelValues("server_error"))
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := autorest.Send(req, p.sendDecorator())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+
+	if got := testutil.ToFloat64(AzureAPIRetriesTotal.WithLabelValues("throttled")) - throttled; got != 1 {
+		t.Errorf("throttled retries = %v, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(AzureAPIRetriesTotal.WithLabelValues("server_error")) - serverError; got != 1 {
+		t.Errorf("server_error retries = %v, want 1", got)
+	}
+
+	// Attempts are exhausted, the last response is returned.
+	atomic.StoreInt32(&requests, 1)
+	p.maxAttempts = 1
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err = autorest.Send(req, p.sendDecorator())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+}
+
+func TestRetryReason(t *testing.T) {
+	urlError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://management.azure.com", Err: err}
+	}
+
+	tests := []struct {
+		name string
+		resp *http.Response
+		err  error
+		want string
+	}{
+		{name: "timeout", err: urlError(&net.OpError{Op: "dial", Err: timeoutError{}}), want: "network"},
+		{name: "reset", err: urlError(&net.OpError{Op: "read", Err: syscall.ECONNRESET}), want: "network"},
+		{name: "refused", err: urlError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), want: "network"},
+		{name: "canceled", err: urlError(context.Canceled), want: ""},
+		{name: "deadline", err: urlError(context.DeadlineExceeded), want: ""},
+		{name: "tls", err: urlError(x509.UnknownAuthorityError{}), want: ""},
+		{name: "dns", err: urlError(&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}), want: ""},
+		{name: "other", err: errors.New("failed"), want: ""},
+		{name: "throttled", resp: &http.Response{StatusCode: http.StatusTooManyRequests}, want: "throttled"},
+		{name: "unavailable", resp: &http.Response{StatusCode: http.StatusServiceUnavailable}, want: "unavailable"},
+		{name: "gateway timeout", resp: &http.Response{StatusCode: http.StatusGatewayTimeout}, want: "timeout"},
+		{name: "server error", resp: &http.Response{StatusCode: http.StatusInternalServerError}, want: "server_error"},
+		{name: "not implemented", resp: &http.Response{StatusCode: http.StatusNotImplemented}, want: ""},
+		{name: "not found", resp: &http.Response{StatusCode: http.StatusNotFound}, want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := retryReason(test.resp, test.err); got != test.want {
+				t.Errorf("retryReason() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error timing out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -30,7 +30,7 @@ var (
 			Name:      "calls_total",
 			Help:      "Total number of calls to the Azure API",
 		},
-		[]string{"subscription", "resource_group", "account"},
+		[]string{"subscription", "resource_group", "account", "service", "operation", "status_code"},
 	)
 
 	// AzureAPIStorageCallsFailedTotal Total number of failed Azure Storage API calls
@@ -41,7 +41,7 @@ var (
 			Name:      "calls_failed_total",
 			Help:      "Total number of failed calls to the Azure API",
 		},
-		[]string{"subscription", "resource_group", "account"},
+		[]string{"subscription", "resource_group", "account", "service", "operation", "status_code"},
 	)
 
 	// AzureAPIStorageCallsDurationSecondsBuckets Histograms of Azure Storage API calls durations in seconds
@@ -53,14 +53,14 @@ var (
 			Help:      "Histograms of successful Azure Storage API calls durations in seconds",
 			Buckets:   []float64{0.02, 0.03, 0.04, 0.05, 0.10, 0.20, 0.30, 0.40, 0.50, 0.75, 1.0, 2.0},
 		},
-		[]string{"subscription", "resource_group", "account"},
+		[]string{"subscription", "resource_group", "account", "service", "operation", "status_code"},
 	)
 )
 
 func init() {
-	prometheus.MustRegister(AzureAPIStorageCallsTotal)
-	prometheus.MustRegister(AzureAPIStorageCallsFailedTotal)
-	prometheus.MustRegister(AzureAPIStorageCallsDurationSecondsBuckets)
+	Registry.MustRegister(AzureAPIStorageCallsTotal)
+	Registry.MustRegister(AzureAPIStorageCallsFailedTotal)
+	Registry.MustRegister(AzureAPIStorageCallsDurationSecondsBuckets)
 }
 
 // ObserveAzureStorageAPICall ...
@@ -74,6 +74,21 @@ func ObserveAzureStorageAPICallFailed(duration float64, labels ...string) {
 	AzureAPIStorageCallsFailedTotal.WithLabelValues(labels...).Inc()
 }
 
+// storageAPICall describes a call made by client for account.
+func storageAPICall(service string, client string, operation string, subscription *subscription.Model, resourceGroup string, account *storage.Account) apiCall {
+	return apiCall{
+		service:        service,
+		client:         client,
+		operation:      operation,
+		subscriptionID: *subscription.SubscriptionID,
+		resourceGroup:  resourceGroup,
+		account:        *account.Name,
+		observe:        ObserveAzureStorageAPICall,
+		observeFailed:  ObserveAzureStorageAPICallFailed,
+		labels:         []string{*subscription.DisplayName, resourceGroup, *account.Name},
+	}
+}
+
 // ListSubscriptionStorageAccounts ...
 func ListSubscriptionStorageAccounts(ctx context.Context, clients *AzureClients, subscription *subscription.Model) (*[]storage.Account, error) {
 	c := cache.GetCache(5*time.Minute, time.Minute)
@@ -92,6 +107,16 @@ func ListSubscriptionStorageAccounts(ctx context.Context, clients *AzureClients,
 		}
 	}
 
+	if inventoryEnabled() {
+		accounts, err := inventoryStorageAccounts(ctx, clients, *subscription.SubscriptionID)
+
+		if err == nil {
+			return accounts, nil
+		}
+
+		contextLogger.Warnf("Failed to list storage accounts from the inventory, falling back to resource manager: %s", err)
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 
@@ -101,19 +126,22 @@ func ListSubscriptionStorageAccounts(ctx context.Context, clients *AzureClients,
 		return nil, err
 	}
 
-	t0 := time.Now()
-	accounts, err := client.List(ctx)
-	t1 := time.Since(t0).Seconds()
+	var accounts storage.AccountListResultPage
+
+	err = observeAPICall(ctx, apiCall{
+		service:        serviceResourceManager,
+		client:         "storage.AccountsClient",
+		operation:      "List",
+		subscriptionID: *subscription.SubscriptionID,
+	}, func(ctx context.Context) (err error) {
+		accounts, err = client.List(ctx)
+		return err
+	})
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-
 	vals := accounts.Values()
 	c.SetDefault(cacheKey, &vals)
 
@@ -155,21 +183,17 @@ func ListStorageAccountContainers(ctx context.Context, clients *AzureClients, su
 		return nil, err
 	}
 
-	t0 := time.Now()
-	containers, err := client.List(ctx, accountDetails.ResourceGroup, *account.Name, "", "", "")
-	t1 := time.Since(t0).Seconds()
+	var containers storage.ListContainerItemsPage
+
+	err = observeAPICall(ctx, storageAPICall(serviceResourceManager, "storage.BlobContainersClient", "List", subscription, accountDetails.ResourceGroup, account), func(ctx context.Context) (err error) {
+		containers, err = client.List(ctx, accountDetails.ResourceGroup, *account.Name, "", "", "")
+		return err
+	})
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-			ObserveAzureStorageAPICallFailed(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-	ObserveAzureStorageAPICall(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
-
 	vals := containers.Values()
 	c.SetDefault(cacheKey, &vals)
 
@@ -211,21 +235,17 @@ func ListStorageAccountKeys(ctx context.Context, clients *AzureClients, subscrip
 		return nil, err
 	}
 
-	t0 := time.Now()
-	keys, err := client.ListKeys(ctx, accountDetails.ResourceGroup, *account.Name, "")
-	t1 := time.Since(t0).Seconds()
+	var keys storage.AccountListKeysResult
+
+	err = observeAPICall(ctx, storageAPICall(serviceResourceManager, "storage.AccountsClient", "ListKeys", subscription, accountDetails.ResourceGroup, account), func(ctx context.Context) (err error) {
+		keys, err = client.ListKeys(ctx, accountDetails.ResourceGroup, *account.Name, "")
+		return err
+	})
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-			ObserveAzureStorageAPICallFailed(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-	ObserveAzureStorageAPICall(t1, *subscription.DisplayName, accountDetails.ResourceGroup, *account.Name)
-
 	vals := *keys.Keys
 	c.SetDefault(cacheKey, &vals)
 
@@ -2,20 +2,16 @@ package azure
 
 import (
 	"context"
-	"fmt"
+	"net/http"
 	"net/url"
-	"time"
 
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-sdk-for-go/services/preview/subscription/mgmt/2018-03-01-preview/subscription"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-05-01/resources"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
 	"github.com/Azure/azure-storage-blob-go/azblob"
 )
 
-var (
-	blobFormatString = `https://%s.blob.core.windows.net`
-)
-
 // StorageAccountContainerWalker in an interface that is to be implemented by struct you want
 // to pass to Walking functions like WalkStorageAccount().
 type StorageAccountContainerWalker interface {
@@ -29,6 +25,12 @@ type StorageAccountContainerWalker interface {
 
 // WalkStorageAccountContainer applies a function on all storage account container blobs.
 func WalkStorageAccountContainer(ctx context.Context, clients *AzureClients, subscription *subscription.Model, account *storage.Account, container *storage.ListContainerItem, walker StorageAccountContainerWalker) error {
+	env, err := GetEnvironment()
+
+	if err != nil {
+		return err
+	}
+
 	token, err := GetStorageToken(ctx)
 
 	if err != nil {
@@ -47,9 +49,15 @@ func WalkStorageAccountContainer(ctx context.Context, clients *AzureClients, sub
 	credential := azblob.NewTokenCredential(accessToken, nil)
 
 	// Preparing browsing container.
-	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
-	url, _ := url.Parse(fmt.Sprintf(blobFormatString, *account.Name))
-	serviceURL := azblob.NewServiceURL(*url, pipeline)
+	// Retries are left to the sender so that the policy of the other clients applies.
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{
+			MaxTries: 1,
+		},
+		HTTPSender: httpSender(clients.HTTPClient(), currentRetryPolicy(), *account.Name),
+	})
+	url, _ := url.Parse(clients.blobServiceURL(env, *account.Name))
+	serviceURL := azblob.NewServiceURL(*url, p)
 	containerURL := serviceURL.NewContainerURL(*container.Name)
 
 	marker := azblob.Marker{}
@@ -60,21 +68,17 @@ func WalkStorageAccountContainer(ctx context.Context, clients *AzureClients, sub
 	}
 
 	for i := 0; ; i++ {
-		t0 := time.Now()
-		list, err := containerURL.ListBlobsFlatSegment(ctx, marker, listOptions)
-		t1 := time.Since(t0).Seconds()
+		var list *azblob.ListBlobsFlatSegmentResponse
+
+		err = observeAPICall(ctx, storageAPICall(serviceStorage, "azblob.ContainerURL", "ListBlobsFlatSegment", subscription, *group.Name, account), func(ctx context.Context) (err error) {
+			list, err = containerURL.ListBlobsFlatSegment(ctx, marker, listOptions)
+			return err
+		})
 
 		if err != nil {
-			if ctx.Err() != context.Canceled {
-				ObserveAzureAPICallFailed(t1)
-				ObserveAzureStorageAPICallFailed(t1, *subscription.DisplayName, *group.Name, *account.Name)
-			}
 			return err
 		}
 
-		ObserveAzureAPICall(t1)
-		ObserveAzureStorageAPICall(t1, *subscription.DisplayName, *group.Name, *account.Name)
-
 		// Update request marker.
 		marker = list.NextMarker
 
@@ -96,3 +100,37 @@ func WalkStorageAccountContainer(ctx context.Context, clients *AzureClients, sub
 
 	return nil
 }
+
+// httpSender returns an azblob pipeline sender sending requests with client
+// and retrying them according to retry. The requests and responses of account
+// are inspected like the ones of the autorest clients.
+func httpSender(client *http.Client, retry retryPolicy, account string) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			ctx = withConnectionTrace(ctx)
+			request.Request = request.WithContext(ctx)
+			setClientRequestID(request.Request, clientRequestIDHeader)
+
+			r, err := retry.do(ctx, func() (*http.Response, error) {
+				if err := request.RewindBody(); err != nil {
+					return nil, err
+				}
+
+				resp, err := client.Do(request.WithContext(ctx))
+				inspectDataPlaneResponse(serviceStorage, account, resp)
+
+				return resp, err
+			})
+
+			traceResponse(r)
+			recordAPICallStatus(r)
+			respondUnauthorized(r)
+
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+
+			return pipeline.NewHTTPResponse(r), err
+		}
+	})
+}
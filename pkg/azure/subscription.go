@@ -30,19 +30,22 @@ func GetSubscription(ctx context.Context, clients *AzureClients, subscriptionID
 		return nil, err
 	}
 
-	t0 := time.Now()
-	sub, err := client.Get(ctx, subscriptionID)
-	t1 := time.Since(t0).Seconds()
+	var sub subscription.Model
+
+	err = observeAPICall(ctx, apiCall{
+		service:        serviceResourceManager,
+		client:         "subscription.SubscriptionsClient",
+		operation:      "Get",
+		subscriptionID: subscriptionID,
+	}, func(ctx context.Context) (err error) {
+		sub, err = client.Get(ctx, subscriptionID)
+		return err
+	})
 
 	if err != nil {
-		if ctx.Err() != context.Canceled {
-			ObserveAzureAPICallFailed(t1)
-		}
 		return nil, err
 	}
 
-	ObserveAzureAPICall(t1)
-
 	c.SetDefault(subscriptionID, &sub)
 
 	return &sub, nil
@@ -0,0 +1,216 @@
+package azure
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+const (
+	defaultThrottlingSlowFactor = 2
+	defaultThrottlingWindow     = 5 * time.Minute
+)
+
+// ThrottleState is the throttling applied to the non critical update metrics
+// functions.
+type ThrottleState int
+
+const (
+	// ThrottleNone lets the functions run at their interval.
+	ThrottleNone ThrottleState = iota
+	// ThrottleSlow runs the functions once every slow_factor intervals.
+	ThrottleSlow
+	// ThrottlePause skips all the runs of the functions.
+	ThrottlePause
+)
+
+// String returns the name of the state.
+func (s ThrottleState) String() string {
+	switch s {
+	case ThrottleSlow:
+		return "slow"
+	case ThrottlePause:
+		return "pause"
+	default:
+		return "none"
+	}
+}
+
+var (
+	// AzureAPIThrottlingState Gauge of the throttling applied to the update metrics functions
+	AzureAPIThrottlingState = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "azure_api",
+			Subsystem: "throttling",
+			Name:      "state",
+			Help:      "Throttling applied to the non critical update metrics functions: 0 none, 1 slow, 2 pause",
+		},
+	)
+
+	// AzureAPIThrottledRunsTotal Total number of update metrics function runs skipped by the throttling
+	AzureAPIThrottledRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "azure_api",
+			Subsystem: "throttling",
+			Name:      "skipped_runs_total",
+			Help:      "Total number of update metrics function runs skipped because of the remaining API calls",
+		},
+		[]string{"function", "state"},
+	)
+)
+
+var (
+	// Throttler fed by the rate limit headers of the responses.
+	apiThrottler = newThrottler(config.ThrottlingConfig{})
+)
+
+func init() {
+	Registry.MustRegister(AzureAPIThrottlingState)
+	Registry.MustRegister(AzureAPIThrottledRunsTotal)
+}
+
+// AllowUpdateMetricsFunctionRun tells whether the update metrics function
+// name can run according to the remaining API calls, along with the current
+// throttling state. Critical functions are always allowed to run.
+func AllowUpdateMetricsFunctionRun(name string) (ThrottleState, bool) {
+	return apiThrottler.allow(name)
+}
+
+// ----------------------------------------------------------------------------
+
+// rateLimitKey identifies a rate limit reported by Azure.
+type rateLimitKey struct {
+	// tenant or subscription
+	scope string
+	id    string
+	write bool
+}
+
+// rateLimitObservation is the last remaining number of calls of a rate limit.
+type rateLimitObservation struct {
+	remaining float64
+	time      time.Time
+}
+
+// throttler computes the throttling state from the remaining API calls.
+// Observations older than the window are forgotten so that the functions
+// resume once Azure has refilled the budget, even if no critical function
+// refreshes the observations.
+type throttler struct {
+	mutex        sync.Mutex
+	conf         config.ThrottlingConfig
+	critical     map[string]bool
+	observations map[rateLimitKey]rateLimitObservation
+	// Number of runs of each function since its last allowed run.
+	skips map[string]int
+	now   func() time.Time
+}
+
+func newThrottler(conf config.ThrottlingConfig) *throttler {
+	t := &throttler{
+		observations: make(map[rateLimitKey]rateLimitObservation),
+		skips:        make(map[string]int),
+		now:          time.Now,
+	}
+
+	t.setConfig(conf)
+
+	return t
+}
+
+// setConfig sets the thresholds, unset values are defaulted.
+func (t *throttler) setConfig(conf config.ThrottlingConfig) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if conf.SlowFactor == 0 {
+		conf.SlowFactor = defaultThrottlingSlowFactor
+	}
+
+	if conf.Window == 0 {
+		conf.Window = defaultThrottlingWindow
+	}
+
+	// api_rate_limiting keeps the remaining calls up to date.
+	if len(conf.CriticalFunctions) == 0 {
+		conf.CriticalFunctions = []string{"api_rate_limiting"}
+	}
+
+	t.conf = conf
+	t.critical = make(map[string]bool)
+
+	for _, name := range conf.CriticalFunctions {
+		t.critical[name] = true
+	}
+}
+
+// observe records the remaining number of calls of a rate limit.
+func (t *throttler) observe(key rateLimitKey, remaining float64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.observations[key] = rateLimitObservation{
+		remaining: remaining,
+		time:      t.now(),
+	}
+
+	AzureAPIThrottlingState.Set(float64(t.state()))
+}
+
+// allow tells whether the function name can run. In slow state, functions run
+// once every slow_factor calls.
+func (t *throttler) allow(name string) (ThrottleState, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	state := t.state()
+	AzureAPIThrottlingState.Set(float64(state))
+
+	if t.critical[name] || state == ThrottleNone {
+		delete(t.skips, name)
+		return state, true
+	}
+
+	if state == ThrottleSlow {
+		t.skips[name]++
+
+		if t.skips[name] >= t.conf.SlowFactor {
+			t.skips[name] = 0
+			return state, true
+		}
+	}
+
+	AzureAPIThrottledRunsTotal.WithLabelValues(name, state.String()).Inc()
+
+	return state, false
+}
+
+// state returns the most restrictive state of the fresh observations and
+// drops the stale ones. It must be called with the mutex held.
+func (t *throttler) state() ThrottleState {
+	state := ThrottleNone
+	now := t.now()
+
+	for key, observation := range t.observations {
+		if now.Sub(observation.time) > t.conf.Window {
+			delete(t.observations, key)
+			continue
+		}
+
+		slow, pause := t.conf.SlowReads, t.conf.PauseReads
+		if key.write {
+			slow, pause = t.conf.SlowWrites, t.conf.PauseWrites
+		}
+
+		switch {
+		case pause > 0 && observation.remaining < float64(pause):
+			return ThrottlePause
+		case slow > 0 && observation.remaining < float64(slow):
+			state = ThrottleSlow
+		}
+	}
+
+	return state
+}
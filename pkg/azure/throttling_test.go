@@ -0,0 +1,75 @@
+package azure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+func TestThrottler(t *testing.T) {
+	now := time.Now()
+	th := newThrottler(config.ThrottlingConfig{
+		SlowReads:   1000,
+		PauseReads:  100,
+		SlowWrites:  100,
+		PauseWrites: 10,
+		SlowFactor:  3,
+		Window:      time.Minute,
+	})
+	th.now = func() time.Time { return now }
+
+	reads := rateLimitKey{"subscription", "sub", false}
+	writes := rateLimitKey{"subscription", "sub", true}
+
+	th.observe(reads, 5000)
+	th.observe(writes, 500)
+
+	if state, ok := th.allow("batch"); state != ThrottleNone || !ok {
+		t.Errorf("allow() = %s, %v, want none, true", state, ok)
+	}
+
+	// Slow: one run out of 3.
+	th.observe(reads, 500)
+
+	allowed := 0
+	for i := 0; i < 6; i++ {
+		if state, ok := th.allow("batch"); state != ThrottleSlow {
+			t.Errorf("state = %s, want slow", state)
+		} else if ok {
+			allowed++
+		}
+	}
+
+	if allowed != 2 {
+		t.Errorf("allowed runs = %d, want 2", allowed)
+	}
+
+	// Pause from writes whatever the reads.
+	th.observe(writes, 5)
+
+	if state, ok := th.allow("batch"); state != ThrottlePause || ok {
+		t.Errorf("allow() = %s, %v, want pause, false", state, ok)
+	}
+
+	// Critical functions always run.
+	if _, ok := th.allow("api_rate_limiting"); !ok {
+		t.Error("api_rate_limiting should not be throttled")
+	}
+
+	// Budget refilled.
+	th.observe(reads, 10000)
+	th.observe(writes, 1000)
+
+	if state, ok := th.allow("batch"); state != ThrottleNone || !ok {
+		t.Errorf("allow() = %s, %v, want none, true", state, ok)
+	}
+
+	// Stale observations are forgotten.
+	th.observe(reads, 50)
+	now = now.Add(2 * time.Minute)
+
+	if state, ok := th.allow("batch"); state != ThrottleNone || !ok {
+		t.Errorf("allow() = %s, %v, want none, true", state, ok)
+	}
+}
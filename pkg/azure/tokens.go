@@ -2,70 +2,32 @@ package azure
 
 import (
 	"context"
-	"os"
-	"time"
 
 	"github.com/Azure/go-autorest/autorest/adal"
-	"github.com/Azure/go-autorest/autorest/azure"
-	log "github.com/sirupsen/logrus"
-	"sylr.dev/libqd/cache"
 )
 
-var (
-	cacheKeyStorageToken = `adal-token`
-)
-
-// GetStorageToken ...
+// GetStorageToken returns the storage data plane token, shared with the
+// storage authorizers through the authorizer registry.
 func GetStorageToken(ctx context.Context) (*adal.ServicePrincipalToken, error) {
-	c := cache.GetCache(1*time.Hour, time.Minute)
-	cacheKey := cacheKeyStorageToken
-
-	contextLogger := log.WithFields(log.Fields{
-		"_id": ctx.Value("id").(string),
-	})
-
-	if ctoken, ok := c.Get(cacheKey); ok {
-		if token, ok := ctoken.(*adal.ServicePrincipalToken); !ok {
-			contextLogger.Errorf("Failed to cast object from cache back to *adal.ServicePrincipalToken")
-		} else {
-			return token, nil
-		}
-	}
-
-	envName := os.Getenv("AZURE_ENVIRONMENT")
-	clientID := os.Getenv("AZURE_CLIENT_ID")
-	clientSecret := os.Getenv("AZURE_CLIENT_SECRET")
-
-	if len(envName) == 0 {
-		envName = azure.PublicCloud.Name
-	}
-
-	env, err := azure.EnvironmentFromName(envName)
+	env, err := GetEnvironment()
 
 	if err != nil {
 		return nil, err
 	}
 
-	oauthConfig, err := adal.NewOAuthConfig(env.ActiveDirectoryEndpoint, os.Getenv("AZURE_TENANT_ID"))
+	entry, err := authorizers.get(storageResource(env))
 
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := adal.NewServicePrincipalToken(*oauthConfig, clientID, clientSecret, "https://storage.azure.com/")
+	// Tokens are acquired lazily and can expire between two calls.
+	err = entry.ensureFresh(ctx)
+	recordAuthenticationError(entry.key, err)
 
 	if err != nil {
 		return nil, err
 	}
 
-	token.SetAutoRefresh(true)
-	err = token.Refresh()
-
-	if err != nil {
-		return nil, err
-	}
-
-	c.SetDefault(cacheKey, token)
-
-	return token, nil
+	return entry.token, nil
 }
@@ -0,0 +1,51 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sylr/prometheus-azure-exporter/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts a span for an Azure API call, empty attributes are omitted.
+func startSpan(ctx context.Context, name string, subscriptionID string, resourceGroup string, account string) (context.Context, trace.Span) {
+	attrs := make([]attribute.KeyValue, 0, 3)
+
+	for key, value := range map[string]string{
+		"azure.subscription":   subscriptionID,
+		"azure.resource_group": resourceGroup,
+		"azure.account":        account,
+	} {
+		if len(value) > 0 {
+			attrs = append(attrs, attribute.String(key, value))
+		}
+	}
+
+	return tracing.Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// traceResponse records the status code of resp on the span of its request.
+func traceResponse(resp *http.Response) {
+	if resp == nil || resp.Request == nil {
+		return
+	}
+
+	setSpanStatusCode(trace.SpanFromContext(resp.Request.Context()), resp.StatusCode)
+}
+
+func setSpanStatusCode(span trace.Span, statusCode int) {
+	span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	flags "github.com/jessevdk/go-flags"
@@ -30,19 +32,23 @@ var (
 
 // PrometheusAzureExporterConfig ...
 type PrometheusAzureExporterConfig struct {
-	ConfigFile        string        `                          short:"f"   long:"config"               description:"Yaml config"`
-	Verbose           []bool        `yaml:"verbose"            short:"v"   long:"verbose"              description:"Show verbose debug information"`
-	JSONOutput        bool          `yaml:"json_output"        short:"j"   long:"json"                 description:"Use json format for output"`
-	Version           bool          `                                      long:"version"              description:"Show version"`
-	ListeningAddress  string        `yaml:"listening_address"  short:"a"   long:"address"              description:"Listening address" env:"LISTENING_ADDRESS" default:"0.0.0.0"`
-	ListeningPort     uint          `yaml:"listening_port"     short:"p"   long:"port"                 description:"Listening port" env:"LISTENING_PORT" default:"9000"`
-	UpdateInterval    time.Duration `yaml:"update_interval"    short:"i"   long:"interval"             description:"Number of seconds between metrics updates" default:"125s"`
-	NoCache           bool          `yaml:"no-cache"                       long:"no-cache"             description:"Disable internal caching"`
-	AutoDiscoveryMode string        `yaml:"autodiscovery_mode" short:"m"   long:"autodiscovery-mode"   description:"Which Azure resources should we pocess: All, Tagged" default:"All"`
-	AutoDiscoveryTag  string        `yaml:"autodiscovery_tag"  short:"t"   long:"autodiscovery-tag"    description:"If discovery mode set to Tagged we process Azure Resources with this tag set to True, If discovery mode set to All, resources with this tag set to False will be discarded" default:"prometheus_io_azure_exporter_discover"`
-
-	// Env vars used for Azure Authent, see
-	// https://github.com/Azure/go-autorest/blob/v13.3.0/autorest/azure/auth/auth.go#L41-L51
+	ConfigFile            string        `                                short:"f"   long:"config"               description:"Yaml config"`
+	WebConfigFile         string        `                                            long:"web-config"           description:"Yaml config of the metrics listener (TLS and basic auth)" env:"WEB_CONFIG_FILE"`
+	Verbose               []bool        `yaml:"verbose"                  short:"v"   long:"verbose"              description:"Show verbose debug information"`
+	JSONOutput            bool          `yaml:"json_output"              short:"j"   long:"json"                 description:"Use json format for output"`
+	Version               bool          `                                            long:"version"              description:"Show version"`
+	Once                  bool          `                                            long:"once"                 description:"Run update metrics functions once, write metrics and exit"`
+	OnceFunctions         []string      `                                            long:"once-function"        description:"Update metrics function to run with --once, can be repeated (default: all enabled functions)"`
+	OnceOutput            string        `                                            long:"once-output"          description:"File the metrics are written to with --once, - for stdout" default:"-"`
+	ListeningAddress      string        `yaml:"listening_address"        short:"a"   long:"address"              description:"Listening address" env:"LISTENING_ADDRESS" default:"0.0.0.0"`
+	ListeningPort         uint          `yaml:"listening_port"           short:"p"   long:"port"                 description:"Listening port" env:"LISTENING_PORT" default:"9000"`
+	DebugListeningAddress string        `yaml:"debug_listening_address"              long:"debug-address"        description:"Listening address (host:port) of the pprof debug server, disabled if empty" env:"DEBUG_LISTENING_ADDRESS"`
+	UpdateInterval        time.Duration `yaml:"update_interval"          short:"i"   long:"interval"             description:"Number of seconds between metrics updates" default:"125s"`
+	NoCache               bool          `yaml:"no-cache"                             long:"no-cache"             description:"Disable internal caching"`
+	AutoDiscoveryMode     string        `yaml:"autodiscovery_mode"       short:"m"   long:"autodiscovery-mode"   description:"Which Azure resources should we pocess: All, Tagged" default:"All"`
+	AutoDiscoveryTag      string        `yaml:"autodiscovery_tag"        short:"t"   long:"autodiscovery-tag"    description:"If discovery mode set to Tagged we process Azure Resources with this tag set to True, If discovery mode set to All, resources with this tag set to False will be discarded" default:"prometheus_io_azure_exporter_discover"`
+
+	// Env vars used for Azure Authent, see pkg/azure/credentials.go
 	AzureTenantID            string `env:"AZURE_TENANT_ID"              description:"Azure tenant id"`
 	AzureSubscriptionID      string `env:"AZURE_SUBSCRIPTION_ID"        description:"Azure subscription id"`
 	AzureClientID            string `env:"AZURE_CLIENT_ID"              description:"Azure client id"`
@@ -53,8 +59,16 @@ type PrometheusAzureExporterConfig struct {
 	AzurePassword            string `env:"AZURE_PASSWORD"               description:"Azure password"`
 	AzureEnvironment         string `env:"AZURE_ENVIRONMENT"            description:"Azure environment"`
 	AzureADResource          string `env:"AZURE_AD_RESOURCE"            description:"Azure AD resource"`
+	AzureCredential          string `env:"AZURE_CREDENTIAL"             description:"Azure credential: client_secret, certificate, managed_identity, workload_identity or azure_cli (default: guessed from env)"`
+	AzureFederatedTokenFile  string `env:"AZURE_FEDERATED_TOKEN_FILE"   description:"Azure workload identity federated token file"`
+	AzureAuthorityHost       string `env:"AZURE_AUTHORITY_HOST"         description:"Azure workload identity authority host"`
 
 	UpdateMetricsFunctions []UpdateMetricsFunctionConfig `yaml:"update_metrics_functions,omitempty"`
+	Azure                  AzureConfig                   `yaml:"azure,omitempty"`
+	Batch                  BatchConfig                   `yaml:"batch,omitempty"`
+	Push                   PushConfig                    `yaml:"push,omitempty"`
+	OTLP                   *OTLPConfig                   `yaml:"otlp,omitempty"`
+	Tracing                *TracingConfig                `yaml:"tracing,omitempty"`
 }
 
 // UpdateMetricsFunctionConfig ...
@@ -63,6 +77,121 @@ type UpdateMetricsFunctionConfig struct {
 	Interval time.Duration `yaml:"interval,omitempty"`
 }
 
+// AzureConfig describes how the Azure APIs are reached.
+type AzureConfig struct {
+	HTTPClient HTTPClientConfig `yaml:"http_client,omitempty"`
+	Endpoints  EndpointsConfig  `yaml:"endpoints,omitempty"`
+	Retry      RetryConfig      `yaml:"retry,omitempty"`
+	Throttling ThrottlingConfig `yaml:"throttling,omitempty"`
+	Inventory  InventoryConfig  `yaml:"inventory,omitempty"`
+}
+
+// HTTPClientConfig describes the http client shared by all the Azure clients.
+type HTTPClientConfig struct {
+	ProxyURL            string        `yaml:"proxy_url,omitempty"`
+	CAFile              string        `yaml:"ca_file,omitempty"`
+	Timeout             time.Duration `yaml:"timeout,omitempty"`
+	DialTimeout         time.Duration `yaml:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout,omitempty"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout,omitempty"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host,omitempty"`
+	DisableKeepAlives   bool          `yaml:"disable_keep_alives,omitempty"`
+}
+
+// EndpointsConfig overrides the endpoints of the Azure environment. Batch and
+// blob endpoints may contain `{account}` which is replaced by the account name.
+type EndpointsConfig struct {
+	ResourceManager string `yaml:"resource_manager,omitempty"`
+	Batch           string `yaml:"batch,omitempty"`
+	Graph           string `yaml:"graph,omitempty"`
+	Blob            string `yaml:"blob,omitempty"`
+}
+
+// RetryConfig describes how the requests to the Azure APIs are retried on
+// network errors, 429 and 5xx responses. The delay between attempts doubles
+// up to MaxBackoff, the Retry-After header of the response takes precedence
+// but is bounded by MaxBackoff too.
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts,omitempty"`
+	Backoff     time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff  time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// ThrottlingConfig describes when the non critical update metrics functions
+// are slowed down or paused according to the remaining API calls reported by
+// Azure in the rate limit headers. Thresholds left to 0 are disabled.
+type ThrottlingConfig struct {
+	SlowReads         int           `yaml:"slow_reads,omitempty"`
+	PauseReads        int           `yaml:"pause_reads,omitempty"`
+	SlowWrites        int           `yaml:"slow_writes,omitempty"`
+	PauseWrites       int           `yaml:"pause_writes,omitempty"`
+	SlowFactor        int           `yaml:"slow_factor,omitempty"`
+	Window            time.Duration `yaml:"window,omitempty"`
+	CriticalFunctions []string      `yaml:"critical_functions,omitempty"`
+}
+
+// InventoryConfig describes how the batch and storage accounts are listed.
+// When ResourceGraph is set, they are listed across Subscriptions with one
+// Azure Resource Graph query instead of one ARM call per subscription and
+// resource type. Subscriptions defaults to AZURE_SUBSCRIPTION_ID.
+type InventoryConfig struct {
+	ResourceGraph bool     `yaml:"resource_graph,omitempty"`
+	Subscriptions []string `yaml:"subscriptions,omitempty"`
+}
+
+// BatchConfig describes how the batch metrics are collected.
+// ListComputeNodes pages through the compute nodes of every pool to count the
+// nodes by state instead of using the pool node counts of the account.
+// NodeMetrics exports metrics for each compute node, of at most
+// MaxNodesPerPool nodes per pool (default: 100).
+type BatchConfig struct {
+	ListComputeNodes bool `yaml:"list_compute_nodes,omitempty"`
+	NodeMetrics      bool `yaml:"node_metrics,omitempty"`
+	MaxNodesPerPool  int  `yaml:"max_nodes_per_pool,omitempty"`
+}
+
+// PushConfig describes where metrics are pushed after each successful run
+// of an update metrics function.
+type PushConfig struct {
+	RemoteWrite *PushTargetConfig `yaml:"remote_write,omitempty"`
+	Pushgateway *PushTargetConfig `yaml:"pushgateway,omitempty"`
+}
+
+// PushTargetConfig ...
+type PushTargetConfig struct {
+	URL            string            `yaml:"url"`
+	Job            string            `yaml:"job,omitempty"`
+	Headers        map[string]string `yaml:"headers,omitempty"`
+	ExternalLabels map[string]string `yaml:"external_labels,omitempty"`
+	Timeout        time.Duration     `yaml:"timeout,omitempty"`
+	MaxRetries     int               `yaml:"max_retries,omitempty"`
+	RetryBackoff   time.Duration     `yaml:"retry_backoff,omitempty"`
+}
+
+// OTLPConfig describes the OpenTelemetry collector metrics are exported to
+// using OTLP, alongside the Prometheus endpoint.
+type OTLPConfig struct {
+	Endpoint           string            `yaml:"endpoint"`
+	Protocol           string            `yaml:"protocol,omitempty"`
+	Insecure           bool              `yaml:"insecure,omitempty"`
+	Headers            map[string]string `yaml:"headers,omitempty"`
+	ResourceAttributes map[string]string `yaml:"resource_attributes,omitempty"`
+	Interval           time.Duration     `yaml:"interval,omitempty"`
+	Timeout            time.Duration     `yaml:"timeout,omitempty"`
+}
+
+// TracingConfig describes the OpenTelemetry collector spans are exported to
+// using OTLP.
+type TracingConfig struct {
+	Endpoint           string            `yaml:"endpoint"`
+	Protocol           string            `yaml:"protocol,omitempty"`
+	Insecure           bool              `yaml:"insecure,omitempty"`
+	Headers            map[string]string `yaml:"headers,omitempty"`
+	ResourceAttributes map[string]string `yaml:"resource_attributes,omitempty"`
+	SampleRatio        *float64          `yaml:"sample_ratio,omitempty"`
+	Timeout            time.Duration     `yaml:"timeout,omitempty"`
+}
+
 // ParseConfigFile parses the config file defined by -f/--config
 func ParseConfigFile() (*PrometheusAzureExporterConfig, error) {
 	if ConfigFromFlagParser == nil || len(ConfigFromFlagParser.ConfigFile) == 0 {
@@ -138,6 +267,50 @@ func ValidateConfig(conf *PrometheusAzureExporterConfig) []error {
 		errs = append(errs, errors.New("config: cannot change listening port"))
 	}
 
+	if CurrentConfig != nil && conf.DebugListeningAddress != CurrentConfig.DebugListeningAddress {
+		errs = append(errs, errors.New("config: cannot change debug listening address"))
+	}
+
+	for name, target := range map[string]*PushTargetConfig{"remote_write": conf.Push.RemoteWrite, "pushgateway": conf.Push.Pushgateway} {
+		if target == nil {
+			continue
+		}
+
+		if u, err := url.Parse(target.URL); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			errs = append(errs, fmt.Errorf("config: `%s` is not a valid %s url", target.URL, name))
+		}
+
+		if target.MaxRetries < 0 {
+			errs = append(errs, fmt.Errorf("config: %s max_retries cannot be negative", name))
+		}
+	}
+
+	errs = append(errs, validateAzureConfig(conf.Azure)...)
+
+	if conf.Batch.MaxNodesPerPool < 0 {
+		errs = append(errs, errors.New("config: batch max_nodes_per_pool cannot be negative"))
+	}
+
+	if conf.OTLP != nil {
+		errs = append(errs, validateOTLPTarget("otlp", conf.OTLP.Endpoint, conf.OTLP.Protocol)...)
+
+		if conf.OTLP.Interval < 0 || conf.OTLP.Timeout < 0 {
+			errs = append(errs, errors.New("config: otlp interval and timeout cannot be negative"))
+		}
+	}
+
+	if conf.Tracing != nil {
+		errs = append(errs, validateOTLPTarget("tracing", conf.Tracing.Endpoint, conf.Tracing.Protocol)...)
+
+		if conf.Tracing.Timeout < 0 {
+			errs = append(errs, errors.New("config: tracing timeout cannot be negative"))
+		}
+
+		if r := conf.Tracing.SampleRatio; r != nil && (*r < 0 || *r > 1) {
+			errs = append(errs, fmt.Errorf("config: tracing sample_ratio must be between 0 and 1, got %v", *r))
+		}
+	}
+
 	switch {
 	case AutoDiscoveryModeAll.MatchString(conf.AutoDiscoveryMode):
 	case AutoDiscoveryModeTagged.MatchString(conf.AutoDiscoveryMode):
@@ -149,6 +322,91 @@ func ValidateConfig(conf *PrometheusAzureExporterConfig) []error {
 	return errs
 }
 
+// validateAzureConfig validates the http client, the retry policy, the
+// throttling and the endpoints used to reach the Azure APIs.
+func validateAzureConfig(conf AzureConfig) []error {
+	errs := make([]error, 0)
+	httpClient := conf.HTTPClient
+
+	if len(httpClient.ProxyURL) > 0 {
+		if u, err := url.Parse(httpClient.ProxyURL); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			errs = append(errs, fmt.Errorf("config: `%s` is not a valid azure proxy_url", httpClient.ProxyURL))
+		}
+	}
+
+	if httpClient.Timeout < 0 || httpClient.DialTimeout < 0 || httpClient.TLSHandshakeTimeout < 0 || httpClient.IdleConnTimeout < 0 {
+		errs = append(errs, errors.New("config: azure http_client timeouts cannot be negative"))
+	}
+
+	if httpClient.MaxIdleConnsPerHost < 0 {
+		errs = append(errs, errors.New("config: azure http_client max_idle_conns_per_host cannot be negative"))
+	}
+
+	if conf.Retry.MaxAttempts < 0 {
+		errs = append(errs, errors.New("config: azure retry max_attempts cannot be negative"))
+	}
+
+	if conf.Retry.Backoff < 0 || conf.Retry.MaxBackoff < 0 {
+		errs = append(errs, errors.New("config: azure retry backoffs cannot be negative"))
+	}
+
+	if conf.Retry.Backoff > 0 && conf.Retry.MaxBackoff > 0 && conf.Retry.Backoff > conf.Retry.MaxBackoff {
+		errs = append(errs, errors.New("config: azure retry backoff cannot be greater than max_backoff"))
+	}
+
+	throttling := conf.Throttling
+
+	if throttling.SlowReads < 0 || throttling.PauseReads < 0 || throttling.SlowWrites < 0 || throttling.PauseWrites < 0 {
+		errs = append(errs, errors.New("config: azure throttling thresholds cannot be negative"))
+	}
+
+	if (throttling.SlowReads > 0 && throttling.PauseReads > throttling.SlowReads) || (throttling.SlowWrites > 0 && throttling.PauseWrites > throttling.SlowWrites) {
+		errs = append(errs, errors.New("config: azure throttling pause thresholds cannot be greater than slow thresholds"))
+	}
+
+	if throttling.SlowFactor < 0 || throttling.Window < 0 {
+		errs = append(errs, errors.New("config: azure throttling slow_factor and window cannot be negative"))
+	}
+
+	endpoints := map[string]string{
+		"resource_manager": conf.Endpoints.ResourceManager,
+		"batch":            conf.Endpoints.Batch,
+		"graph":            conf.Endpoints.Graph,
+		"blob":             conf.Endpoints.Blob,
+	}
+
+	for name, endpoint := range endpoints {
+		if len(endpoint) == 0 {
+			continue
+		}
+
+		u, err := url.Parse(strings.ReplaceAll(endpoint, "{account}", "account"))
+
+		if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			errs = append(errs, fmt.Errorf("config: `%s` is not a valid azure %s endpoint", endpoint, name))
+		}
+	}
+
+	return errs
+}
+
+// validateOTLPTarget validates the endpoint and protocol of an OTLP exporter.
+func validateOTLPTarget(name string, endpoint string, protocol string) []error {
+	errs := make([]error, 0)
+
+	if len(endpoint) == 0 {
+		errs = append(errs, fmt.Errorf("config: %s endpoint cannot be empty", name))
+	}
+
+	switch protocol {
+	case "", "grpc", "http":
+	default:
+		errs = append(errs, fmt.Errorf("config: `%s` is not a valid %s protocol, must be grpc or http", protocol, name))
+	}
+
+	return errs
+}
+
 // MustDiscoverBasedOnTags tags an map of tags returns True if the object
 // must be discovered based on autodiscovery mode.
 func MustDiscoverBasedOnTags(tags map[string]*string) bool {
@@ -36,7 +36,7 @@ func UpdateAPIRateLimitingMetrics(ctx context.Context) error {
 	// - pkg/azure/azure.go   -> SetReadRateLimitRemaining()
 	//                           SetWriteRateLimitRemaining()
 
-	azureClients := azure.NewAzureClients()
+	azureClients := azure.AzureClientsFromContext(ctx)
 	sub, err := azure.GetSubscription(ctx, azureClients, os.Getenv("AZURE_SUBSCRIPTION_ID"))
 
 	if err != nil {
@@ -3,7 +3,11 @@ package metrics
 import (
 	"context"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/batch/2019-08-01.10.0/batch"
 	azurebatch "github.com/Azure/azure-sdk-for-go/services/batch/mgmt/2019-08-01/batch"
@@ -15,21 +19,49 @@ import (
 )
 
 var (
-	mu                        = sync.Mutex{}
-	batchPoolQuota            = newBatchPoolQuota()
-	batchDedicatedCoreQuota   = newBatchDedicatedCoreQuota()
-	batchPoolsDedicatedNodes  = newBatchPoolsDedicatedNodes()
-	batchPoolsNodesState      = newBatchPoolsNodesState()
-	batchPoolsAllocationState = newBatchPoolsAllocationState()
-	batchPoolsMetadata        = newBatchPoolsMetadata()
-	batchJobsTasksActive      = newBatchJobsTasksActive()
-	batchJobsTasksRunning     = newBatchJobsTasksRunning()
-	batchJobsTasksCompleted   = newBatchJobsTasksCompleted()
-	batchJobsTasksSucceeded   = newBatchJobsTasksSucceeded()
-	batchJobsTasksFailed      = newBatchJobsTasksFailed()
-	batchJobsInfo             = newBatchJobsInfo()
-	batchJobsStates           = newBatchJobsStates()
-	batchJobsMetadata         = newBatchJobsMetadata()
+	mu                                = sync.Mutex{}
+	batchPoolQuota                    = newBatchPoolQuota()
+	batchDedicatedCoreQuota           = newBatchDedicatedCoreQuota()
+	batchPoolsDedicatedNodes          = newBatchPoolsDedicatedNodes()
+	batchPoolsNodesState              = newBatchPoolsNodesState()
+	batchPoolsLowPriorityNodes        = newBatchPoolsLowPriorityNodes()
+	batchPoolsTargetLowPriorityNodes  = newBatchPoolsTargetLowPriorityNodes()
+	batchPoolsDedicatedNodesState     = newBatchPoolsDedicatedNodesState()
+	batchPoolsLowPriorityNodesState   = newBatchPoolsLowPriorityNodesState()
+	batchPoolsTargetDedicatedNodes    = newBatchPoolsTargetDedicatedNodes()
+	batchPoolsAutoScaleEnabled        = newBatchPoolsAutoScaleEnabled()
+	batchPoolsAutoScaleEvaluationTime = newBatchPoolsAutoScaleEvaluationTime()
+	batchPoolsAutoScaleError          = newBatchPoolsAutoScaleError()
+	batchPoolsResizeErrors            = newBatchPoolsResizeErrors()
+	batchPoolsResizeOperationAge      = newBatchPoolsResizeOperationAge()
+	batchPoolsInfo                    = newBatchPoolsInfo()
+	batchPoolsCreationTime            = newBatchPoolsCreationTime()
+	batchPoolsAllocationState         = newBatchPoolsAllocationState()
+	batchPoolsMetadata                = newBatchPoolsMetadata()
+	batchJobsTasksActive              = newBatchJobsTasksActive()
+	batchJobsTasksRunning             = newBatchJobsTasksRunning()
+	batchJobsTasksCompleted           = newBatchJobsTasksCompleted()
+	batchJobsTasksSucceeded           = newBatchJobsTasksSucceeded()
+	batchJobsTasksFailed              = newBatchJobsTasksFailed()
+	batchJobsInfo                     = newBatchJobsInfo()
+	batchJobsStates                   = newBatchJobsStates()
+	batchJobsMetadata                 = newBatchJobsMetadata()
+	batchNodesState                   = newBatchNodesState()
+	batchNodesRunningTasks            = newBatchNodesRunningTasks()
+	batchNodesTasksRun                = newBatchNodesTasksRun()
+	batchNodesTasksSucceeded          = newBatchNodesTasksSucceeded()
+	batchNodesStartTaskState          = newBatchNodesStartTaskState()
+	batchNodesStartTaskExitCode       = newBatchNodesStartTaskExitCode()
+	batchNodesErrors                  = newBatchNodesErrors()
+	batchNodesAgentInfo               = newBatchNodesAgentInfo()
+	batchNodesUptime                  = newBatchNodesUptime()
+	batchPoolsSkippedNodes            = newBatchPoolsSkippedNodes()
+
+	// batchPoolsPreemptions is not swapped between runs, preemptions are
+	// counted from the preempted nodes of the previous run.
+	batchPoolsPreemptions   = newBatchPoolsPreemptions()
+	batchPoolsPreemptedMu   = sync.Mutex{}
+	batchPoolsPreemptedLast = make(map[string]batchPoolPreempted)
 )
 
 // -----------------------------------------------------------------------------
@@ -82,6 +114,162 @@ func newBatchPoolsNodesState() *prometheus.GaugeVec {
 	)
 }
 
+func newBatchPoolsLowPriorityNodes() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_low_priority_nodes",
+			Help:      "Number of low priority nodes for batch pool",
+		},
+		[]string{"subscription", "resource_group", "account", "pool"},
+	)
+}
+
+func newBatchPoolsTargetLowPriorityNodes() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_target_low_priority_nodes",
+			Help:      "Target number of low priority nodes for batch pool",
+		},
+		[]string{"subscription", "resource_group", "account", "pool"},
+	)
+}
+
+func newBatchPoolsTargetDedicatedNodes() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_target_dedicated_nodes",
+			Help:      "Target number of dedicated nodes for batch pool",
+		},
+		[]string{"subscription", "resource_group", "account", "pool"},
+	)
+}
+
+func newBatchPoolsAutoScaleEnabled() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_autoscale_enabled",
+			Help:      "Whether the batch pool is autoscaled",
+		},
+		[]string{"subscription", "resource_group", "account", "pool"},
+	)
+}
+
+func newBatchPoolsAutoScaleEvaluationTime() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_autoscale_evaluation_time",
+			Help:      "Timestamp of the last autoscale evaluation of the batch pool",
+		},
+		[]string{"subscription", "resource_group", "account", "pool"},
+	)
+}
+
+func newBatchPoolsAutoScaleError() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_autoscale_error",
+			Help:      "Error of the last autoscale evaluation of the batch pool",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "code"},
+	)
+}
+
+func newBatchPoolsResizeErrors() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_resize_errors",
+			Help:      "Number of errors of the last resize of the batch pool by code",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "code"},
+	)
+}
+
+func newBatchPoolsResizeOperationAge() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_resize_operation_age_seconds",
+			Help:      "Age of the ongoing resize of the batch pool",
+		},
+		[]string{"subscription", "resource_group", "account", "pool"},
+	)
+}
+
+func newBatchPoolsInfo() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_info",
+			Help:      "Informative vector about pool",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "vm_size", "image_publisher", "image_offer", "image_sku", "image_version", "node_agent_sku", "task_slots_per_node", "inter_node_communication", "subnet_id"},
+	)
+}
+
+func newBatchPoolsCreationTime() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_creation_time",
+			Help:      "Timestamp of the creation of the batch pool",
+		},
+		[]string{"subscription", "resource_group", "account", "pool"},
+	)
+}
+
+func newBatchPoolsDedicatedNodesState() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_dedicated_node_state",
+			Help:      "Number of dedicated nodes for each states",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "state"},
+	)
+}
+
+func newBatchPoolsLowPriorityNodesState() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_low_priority_node_state",
+			Help:      "Number of low priority nodes for each states",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "state"},
+	)
+}
+
+func newBatchPoolsPreemptions() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_node_preemptions_total",
+			Help:      "Total number of low priority nodes seen moving into the preempted state",
+		},
+		[]string{"subscription", "resource_group", "account", "pool"},
+	)
+}
+
 func newBatchPoolsAllocationState() *prometheus.GaugeVec {
 	return prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -202,29 +390,475 @@ func newBatchJobsMetadata() *prometheus.GaugeVec {
 	)
 }
 
+func newBatchNodesState() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "node_state",
+			Help:      "State of node",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "node", "state"},
+	)
+}
+
+func newBatchNodesRunningTasks() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "node_running_tasks",
+			Help:      "Number of tasks running on node",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "node"},
+	)
+}
+
+func newBatchNodesTasksRun() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "node_tasks_run_total",
+			Help:      "Total number of tasks run on node",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "node"},
+	)
+}
+
+func newBatchNodesTasksSucceeded() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "node_tasks_succeeded_total",
+			Help:      "Total number of tasks succeeded on node",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "node"},
+	)
+}
+
+func newBatchNodesStartTaskState() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "node_start_task_state",
+			Help:      "State of the start task of node",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "node", "state"},
+	)
+}
+
+func newBatchNodesStartTaskExitCode() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "node_start_task_exit_code",
+			Help:      "Exit code of the start task of node",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "node"},
+	)
+}
+
+func newBatchNodesErrors() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "node_errors",
+			Help:      "Number of errors of node by code",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "node", "code"},
+	)
+}
+
+func newBatchNodesAgentInfo() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "node_agent_info",
+			Help:      "Informative vector with node agent version",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "node", "version"},
+	)
+}
+
+func newBatchNodesUptime() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "node_uptime_seconds",
+			Help:      "Time since node last boot",
+		},
+		[]string{"subscription", "resource_group", "account", "pool", "node"},
+	)
+}
+
+func newBatchPoolsSkippedNodes() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "azure",
+			Subsystem: "batch",
+			Name:      "pool_node_metrics_skipped_nodes",
+			Help:      "Number of nodes of batch pool without node metrics because of max_nodes_per_pool",
+		},
+		[]string{"subscription", "resource_group", "account", "pool"},
+	)
+}
+
 // -----------------------------------------------------------------------------
 
 func init() {
-	prometheus.MustRegister(batchPoolQuota)
-	prometheus.MustRegister(batchDedicatedCoreQuota)
-	prometheus.MustRegister(batchPoolsDedicatedNodes)
-	prometheus.MustRegister(batchPoolsNodesState)
-	prometheus.MustRegister(batchPoolsAllocationState)
-	prometheus.MustRegister(batchPoolsMetadata)
-	prometheus.MustRegister(batchJobsTasksActive)
-	prometheus.MustRegister(batchJobsTasksRunning)
-	prometheus.MustRegister(batchJobsTasksCompleted)
-	prometheus.MustRegister(batchJobsTasksSucceeded)
-	prometheus.MustRegister(batchJobsTasksFailed)
-	prometheus.MustRegister(batchJobsInfo)
-	prometheus.MustRegister(batchJobsStates)
-	prometheus.MustRegister(batchJobsMetadata)
+	registries[moduleBatch].MustRegister(batchPoolQuota)
+	registries[moduleBatch].MustRegister(batchDedicatedCoreQuota)
+	registries[moduleBatch].MustRegister(batchPoolsDedicatedNodes)
+	registries[moduleBatch].MustRegister(batchPoolsNodesState)
+	registries[moduleBatch].MustRegister(batchPoolsLowPriorityNodes)
+	registries[moduleBatch].MustRegister(batchPoolsTargetLowPriorityNodes)
+	registries[moduleBatch].MustRegister(batchPoolsDedicatedNodesState)
+	registries[moduleBatch].MustRegister(batchPoolsLowPriorityNodesState)
+	registries[moduleBatch].MustRegister(batchPoolsTargetDedicatedNodes)
+	registries[moduleBatch].MustRegister(batchPoolsAutoScaleEnabled)
+	registries[moduleBatch].MustRegister(batchPoolsAutoScaleEvaluationTime)
+	registries[moduleBatch].MustRegister(batchPoolsAutoScaleError)
+	registries[moduleBatch].MustRegister(batchPoolsResizeErrors)
+	registries[moduleBatch].MustRegister(batchPoolsResizeOperationAge)
+	registries[moduleBatch].MustRegister(batchPoolsInfo)
+	registries[moduleBatch].MustRegister(batchPoolsCreationTime)
+	registries[moduleBatch].MustRegister(batchPoolsPreemptions)
+	registries[moduleBatch].MustRegister(batchPoolsAllocationState)
+	registries[moduleBatch].MustRegister(batchPoolsMetadata)
+	registries[moduleBatch].MustRegister(batchJobsTasksActive)
+	registries[moduleBatch].MustRegister(batchJobsTasksRunning)
+	registries[moduleBatch].MustRegister(batchJobsTasksCompleted)
+	registries[moduleBatch].MustRegister(batchJobsTasksSucceeded)
+	registries[moduleBatch].MustRegister(batchJobsTasksFailed)
+	registries[moduleBatch].MustRegister(batchJobsInfo)
+	registries[moduleBatch].MustRegister(batchJobsStates)
+	registries[moduleBatch].MustRegister(batchJobsMetadata)
+	registries[moduleBatch].MustRegister(batchNodesState)
+	registries[moduleBatch].MustRegister(batchNodesRunningTasks)
+	registries[moduleBatch].MustRegister(batchNodesTasksRun)
+	registries[moduleBatch].MustRegister(batchNodesTasksSucceeded)
+	registries[moduleBatch].MustRegister(batchNodesStartTaskState)
+	registries[moduleBatch].MustRegister(batchNodesStartTaskExitCode)
+	registries[moduleBatch].MustRegister(batchNodesErrors)
+	registries[moduleBatch].MustRegister(batchNodesAgentInfo)
+	registries[moduleBatch].MustRegister(batchNodesUptime)
+	registries[moduleBatch].MustRegister(batchPoolsSkippedNodes)
 
 	if GetUpdateMetricsFunctionInterval("batch") == nil {
 		RegisterUpdateMetricsFunction("batch", UpdateBatchMetrics)
 	}
 }
 
+// listComputeNodes tells whether the nodes state is counted by listing the
+// compute nodes of every pool instead of using the pool node counts.
+func listComputeNodes() bool {
+	return config.CurrentConfig != nil && config.CurrentConfig.Batch.ListComputeNodes
+}
+
+// nodeMetrics tells whether metrics are exported for each compute node.
+func nodeMetrics() bool {
+	return config.CurrentConfig != nil && config.CurrentConfig.Batch.NodeMetrics
+}
+
+// maxNodesPerPool returns the max number of nodes per pool with node metrics.
+func maxNodesPerPool() int {
+	if config.CurrentConfig != nil && config.CurrentConfig.Batch.MaxNodesPerPool > 0 {
+		return config.CurrentConfig.Batch.MaxNodesPerPool
+	}
+
+	return 100
+}
+
+// nodeCountsByState returns the node counts by state.
+func nodeCountsByState(counts *batch.NodeCounts) map[batch.ComputeNodeState]int32 {
+	states := make(map[batch.ComputeNodeState]int32)
+
+	if counts == nil {
+		return states
+	}
+
+	for state, count := range map[batch.ComputeNodeState]*int32{
+		batch.Creating:            counts.Creating,
+		batch.Idle:                counts.Idle,
+		batch.LeavingPool:         counts.LeavingPool,
+		batch.Offline:             counts.Offline,
+		batch.Preempted:           counts.Preempted,
+		batch.Rebooting:           counts.Rebooting,
+		batch.Reimaging:           counts.Reimaging,
+		batch.Running:             counts.Running,
+		batch.Starting:            counts.Starting,
+		batch.StartTaskFailed:     counts.StartTaskFailed,
+		batch.Unknown:             counts.Unknown,
+		batch.Unusable:            counts.Unusable,
+		batch.WaitingForStartTask: counts.WaitingForStartTask,
+	} {
+		if count != nil {
+			states[state] = *count
+		}
+	}
+
+	return states
+}
+
+// computeNodesByState counts the dedicated and low priority nodes by state.
+func computeNodesByState(nodes []batch.ComputeNode) (dedicated map[batch.ComputeNodeState]int32, lowPriority map[batch.ComputeNodeState]int32) {
+	dedicated = make(map[batch.ComputeNodeState]int32)
+	lowPriority = make(map[batch.ComputeNodeState]int32)
+
+	for _, node := range nodes {
+		if node.IsDedicated != nil && *node.IsDedicated {
+			dedicated[node.State]++
+		} else {
+			lowPriority[node.State]++
+		}
+	}
+
+	return dedicated, lowPriority
+}
+
+// poolTargetNodes returns the target dedicated and low priority nodes of the
+// pool, the ones of its last resize when it is autoscaled.
+func poolTargetNodes(pool *azurebatch.Pool) (dedicated *int32, lowPriority *int32) {
+	if pool.ScaleSettings != nil && pool.ScaleSettings.FixedScale != nil {
+		return pool.ScaleSettings.FixedScale.TargetDedicatedNodes, pool.ScaleSettings.FixedScale.TargetLowPriorityNodes
+	}
+
+	if pool.ResizeOperationStatus != nil {
+		return pool.ResizeOperationStatus.TargetDedicatedNodes, pool.ResizeOperationStatus.TargetLowPriorityNodes
+	}
+
+	return nil, nil
+}
+
+// poolResizeStartTime returns the start time of the ongoing resize of the pool.
+func poolResizeStartTime(pool *azurebatch.Pool) (time.Time, bool) {
+	if pool.AllocationState != azurebatch.Resizing {
+		return time.Time{}, false
+	}
+
+	if pool.ResizeOperationStatus != nil && pool.ResizeOperationStatus.StartTime != nil {
+		return pool.ResizeOperationStatus.StartTime.Time, true
+	}
+
+	if pool.AllocationStateTransitionTime != nil {
+		return pool.AllocationStateTransitionTime.Time, true
+	}
+
+	return time.Time{}, false
+}
+
+// poolInfoLabels returns the values of the azure_batch_pool_info labels
+// describing the pool, empty when unknown. The creation time is exported by
+// azure_batch_pool_creation_time so that recreating a pool does not change
+// its info series.
+func poolInfoLabels(pool *azurebatch.Pool) []string {
+	value := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+
+		return *s
+	}
+
+	var vmSize, publisher, offer, sku, version, nodeAgentSku, taskSlots, subnetID string
+
+	vmSize = value(pool.VMSize)
+
+	if pool.DeploymentConfiguration != nil && pool.DeploymentConfiguration.VirtualMachineConfiguration != nil {
+		vmConfiguration := pool.DeploymentConfiguration.VirtualMachineConfiguration
+		nodeAgentSku = value(vmConfiguration.NodeAgentSkuID)
+
+		if vmConfiguration.ImageReference != nil {
+			publisher = value(vmConfiguration.ImageReference.Publisher)
+			offer = value(vmConfiguration.ImageReference.Offer)
+			sku = value(vmConfiguration.ImageReference.Sku)
+			version = value(vmConfiguration.ImageReference.Version)
+		}
+	}
+
+	// Task slots per node are the max tasks per node in this API version
+	if pool.MaxTasksPerNode != nil {
+		taskSlots = strconv.Itoa(int(*pool.MaxTasksPerNode))
+	}
+
+	if pool.NetworkConfiguration != nil {
+		subnetID = value(pool.NetworkConfiguration.SubnetID)
+	}
+
+	return []string{vmSize, publisher, offer, sku, version, nodeAgentSku, taskSlots, string(pool.InterNodeCommunication), subnetID}
+}
+
+// batchNodes holds the node metrics vectors of a run.
+type batchNodes struct {
+	state          *prometheus.GaugeVec
+	runningTasks   *prometheus.GaugeVec
+	tasksRun       *prometheus.CounterVec
+	tasksSucceeded *prometheus.CounterVec
+	startTaskState *prometheus.GaugeVec
+	startTaskExit  *prometheus.GaugeVec
+	errors         *prometheus.GaugeVec
+	agentInfo      *prometheus.GaugeVec
+	uptime         *prometheus.GaugeVec
+	skippedNodes   *prometheus.GaugeVec
+}
+
+// set sets the metrics of at most maxNodes nodes of the pool identified by
+// labels. Nodes which are neither idle nor running come first as they are the
+// ones worth debugging. Nodes without id can not be labelled and are skipped.
+func (b batchNodes) set(labels []string, nodes []batch.ComputeNode, maxNodes int) {
+	nodes = append([]batch.ComputeNode(nil), nodes...)
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		iHealthy, jHealthy := healthyComputeNode(nodes[i]), healthyComputeNode(nodes[j])
+
+		if iHealthy != jHealthy {
+			return !iHealthy
+		}
+
+		if nodes[i].ID == nil || nodes[j].ID == nil {
+			return nodes[i].ID != nil && nodes[j].ID == nil
+		}
+
+		return *nodes[i].ID < *nodes[j].ID
+	})
+
+	skipped := 0
+
+	if len(nodes) > maxNodes {
+		skipped = len(nodes) - maxNodes
+		nodes = nodes[:maxNodes]
+	}
+
+	for _, node := range nodes {
+		if node.ID == nil {
+			skipped++
+			continue
+		}
+
+		nodeLabels := append(append(make([]string, 0, len(labels)+2), labels...), *node.ID)
+		with := func(values ...string) []string {
+			return append(append(make([]string, 0, len(nodeLabels)+len(values)), nodeLabels...), values...)
+		}
+
+		b.state.WithLabelValues(with(string(node.State))...).Set(1)
+
+		if node.RunningTasksCount != nil {
+			b.runningTasks.WithLabelValues(nodeLabels...).Set(float64(*node.RunningTasksCount))
+		}
+
+		if node.TotalTasksRun != nil {
+			b.tasksRun.WithLabelValues(nodeLabels...).Set(float64(*node.TotalTasksRun))
+		}
+
+		if node.TotalTasksSucceeded != nil {
+			b.tasksSucceeded.WithLabelValues(nodeLabels...).Set(float64(*node.TotalTasksSucceeded))
+		}
+
+		if node.StartTaskInfo != nil {
+			b.startTaskState.WithLabelValues(with(string(node.StartTaskInfo.State))...).Set(1)
+
+			if node.StartTaskInfo.ExitCode != nil {
+				b.startTaskExit.WithLabelValues(nodeLabels...).Set(float64(*node.StartTaskInfo.ExitCode))
+			}
+		}
+
+		if node.Errors != nil {
+			for _, nodeError := range *node.Errors {
+				if nodeError.Code != nil {
+					b.errors.WithLabelValues(with(*nodeError.Code)...).Inc()
+				}
+			}
+		}
+
+		if node.NodeAgentInfo != nil && node.NodeAgentInfo.Version != nil {
+			b.agentInfo.WithLabelValues(with(*node.NodeAgentInfo.Version)...).Set(1)
+		}
+
+		if node.LastBootTime != nil {
+			b.uptime.WithLabelValues(nodeLabels...).Set(time.Since(node.LastBootTime.Time).Seconds())
+		}
+	}
+
+	b.skippedNodes.WithLabelValues(labels...).Set(float64(skipped))
+}
+
+// healthyComputeNode tells whether the node is idle or running.
+func healthyComputeNode(node batch.ComputeNode) bool {
+	return node.State == batch.Idle || node.State == batch.Running
+}
+
+// batchPoolNodesState holds the node state vectors of a run.
+type batchPoolNodesState struct {
+	all         *prometheus.GaugeVec
+	dedicated   *prometheus.GaugeVec
+	lowPriority *prometheus.GaugeVec
+}
+
+// set sets the number of nodes of the pool identified by labels for every
+// state and counts its preemptions.
+func (s batchPoolNodesState) set(labels []string, dedicated map[batch.ComputeNodeState]int32, lowPriority map[batch.ComputeNodeState]int32) {
+	for _, state := range batch.PossibleComputeNodeStateValues() {
+		stateLabels := append(append(make([]string, 0, len(labels)+1), labels...), string(state))
+
+		s.all.WithLabelValues(stateLabels...).Set(float64(dedicated[state] + lowPriority[state]))
+		s.dedicated.WithLabelValues(stateLabels...).Set(float64(dedicated[state]))
+		s.lowPriority.WithLabelValues(stateLabels...).Set(float64(lowPriority[state]))
+	}
+
+	observeBatchPoolPreemptions(labels, lowPriority[batch.Preempted])
+}
+
+// observeBatchPoolPreemptions counts the increase of the preempted nodes of
+// the pool identified by labels since the previous run. Nodes preempted and
+// recovered between two runs are missed.
+func observeBatchPoolPreemptions(labels []string, preempted int32) {
+	key := strings.Join(labels, "/")
+
+	batchPoolsPreemptedMu.Lock()
+	defer batchPoolsPreemptedMu.Unlock()
+
+	last, ok := batchPoolsPreemptedLast[key]
+	batchPoolsPreemptedLast[key] = batchPoolPreempted{labels: labels, preempted: preempted}
+
+	// Counter initialized on the first run of the pool
+	counter := batchPoolsPreemptions.WithLabelValues(labels...)
+
+	if ok && preempted > last.preempted {
+		counter.Add(float64(preempted - last.preempted))
+	}
+}
+
+// batchPoolPreempted is the number of preempted nodes of a pool seen in the
+// previous run.
+type batchPoolPreempted struct {
+	labels    []string
+	preempted int32
+}
+
+// pruneBatchPoolPreemptions forgets the preemptions of the pools which have
+// not been seen in the run, keyed like observeBatchPoolPreemptions, unless
+// the pools of their account could not be listed.
+func pruneBatchPoolPreemptions(seenPools map[string]bool, failedAccounts map[string]bool) {
+	batchPoolsPreemptedMu.Lock()
+	defer batchPoolsPreemptedMu.Unlock()
+
+	for key, last := range batchPoolsPreemptedLast {
+		if seenPools[key] || failedAccounts[strings.Join(last.labels[:len(last.labels)-1], "/")] {
+			continue
+		}
+
+		batchPoolsPreemptions.DeleteLabelValues(last.labels...)
+		delete(batchPoolsPreemptedLast, key)
+	}
+}
+
 // UpdateBatchMetrics updates batch metrics
 func UpdateBatchMetrics(ctx context.Context) error {
 	var err error
@@ -234,7 +868,7 @@ func UpdateBatchMetrics(ctx context.Context) error {
 		"_func": "UpdateBatchMetrics",
 	})
 
-	azureClients := azure.NewAzureClients()
+	azureClients := azure.AzureClientsFromContext(ctx)
 	sub, err := azure.GetSubscription(ctx, azureClients, os.Getenv("AZURE_SUBSCRIPTION_ID"))
 
 	if err != nil {
@@ -254,6 +888,18 @@ func UpdateBatchMetrics(ctx context.Context) error {
 	nextBatchDedicatedCoreQuota := newBatchDedicatedCoreQuota()
 	nextBatchPoolsDedicatedNodes := newBatchPoolsDedicatedNodes()
 	nextBatchPoolsNodesState := newBatchPoolsNodesState()
+	nextBatchPoolsLowPriorityNodes := newBatchPoolsLowPriorityNodes()
+	nextBatchPoolsTargetLowPriorityNodes := newBatchPoolsTargetLowPriorityNodes()
+	nextBatchPoolsDedicatedNodesState := newBatchPoolsDedicatedNodesState()
+	nextBatchPoolsLowPriorityNodesState := newBatchPoolsLowPriorityNodesState()
+	nextBatchPoolsTargetDedicatedNodes := newBatchPoolsTargetDedicatedNodes()
+	nextBatchPoolsAutoScaleEnabled := newBatchPoolsAutoScaleEnabled()
+	nextBatchPoolsAutoScaleEvaluationTime := newBatchPoolsAutoScaleEvaluationTime()
+	nextBatchPoolsAutoScaleError := newBatchPoolsAutoScaleError()
+	nextBatchPoolsResizeErrors := newBatchPoolsResizeErrors()
+	nextBatchPoolsResizeOperationAge := newBatchPoolsResizeOperationAge()
+	nextBatchPoolsInfo := newBatchPoolsInfo()
+	nextBatchPoolsCreationTime := newBatchPoolsCreationTime()
 	nextBatchPoolsAllocationState := newBatchPoolsAllocationState()
 	nextBatchPoolsMetadata := newBatchPoolsMetadata()
 	nextBatchJobsTasksActive := newBatchJobsTasksActive()
@@ -264,6 +910,39 @@ func UpdateBatchMetrics(ctx context.Context) error {
 	nextBatchJobsInfo := newBatchJobsInfo()
 	nextBatchJobsStates := newBatchJobsStates()
 	nextBatchJobsMetadata := newBatchJobsMetadata()
+	nextBatchNodesState := newBatchNodesState()
+	nextBatchNodesRunningTasks := newBatchNodesRunningTasks()
+	nextBatchNodesTasksRun := newBatchNodesTasksRun()
+	nextBatchNodesTasksSucceeded := newBatchNodesTasksSucceeded()
+	nextBatchNodesStartTaskState := newBatchNodesStartTaskState()
+	nextBatchNodesStartTaskExitCode := newBatchNodesStartTaskExitCode()
+	nextBatchNodesErrors := newBatchNodesErrors()
+	nextBatchNodesAgentInfo := newBatchNodesAgentInfo()
+	nextBatchNodesUptime := newBatchNodesUptime()
+	nextBatchPoolsSkippedNodes := newBatchPoolsSkippedNodes()
+
+	nextBatchPoolsNodesStates := batchPoolNodesState{
+		all:         nextBatchPoolsNodesState,
+		dedicated:   nextBatchPoolsDedicatedNodesState,
+		lowPriority: nextBatchPoolsLowPriorityNodesState,
+	}
+
+	nextBatchNodes := batchNodes{
+		state:          nextBatchNodesState,
+		runningTasks:   nextBatchNodesRunningTasks,
+		tasksRun:       nextBatchNodesTasksRun,
+		tasksSucceeded: nextBatchNodesTasksSucceeded,
+		startTaskState: nextBatchNodesStartTaskState,
+		startTaskExit:  nextBatchNodesStartTaskExitCode,
+		errors:         nextBatchNodesErrors,
+		agentInfo:      nextBatchNodesAgentInfo,
+		uptime:         nextBatchNodesUptime,
+		skippedNodes:   nextBatchPoolsSkippedNodes,
+	}
+
+	// Pools seen in the run and accounts whose pools could not be listed
+	seenPools := make(map[string]bool)
+	failedAccounts := make(map[string]bool)
 
 	wg := qdsync.NewCancelableWaitGroup(ctx, 50)
 
@@ -292,8 +971,11 @@ func UpdateBatchMetrics(ctx context.Context) error {
 
 		if err != nil {
 			accountLogger.Errorf("Unable to list account `%s` pools: %s", *(*batchAccounts)[i].Name, err)
+			failedAccounts[strings.Join([]string{*sub.DisplayName, accountProperties.ResourceGroup, *(*batchAccounts)[i].Name}, "/")] = true
 		} else {
 			for _, pool := range pools {
+				seenPools[strings.Join([]string{*sub.DisplayName, accountProperties.ResourceGroup, *(*batchAccounts)[i].Name, *pool.Name}, "/")] = true
+
 				wg.Add(1)
 
 				go func(account *azurebatch.Account, pool azurebatch.Pool) {
@@ -304,12 +986,57 @@ func UpdateBatchMetrics(ctx context.Context) error {
 
 					nextBatchPoolsAllocationState.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name, string(pool.AllocationState)).Set(1)
 
-					// Nodes state
-					for _, state := range batch.PossibleComputeNodeStateValues() {
-						nextBatchPoolsNodesState.DeleteLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name, string(state))
+					nextBatchPoolsDedicatedNodes.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(float64(*pool.PoolProperties.CurrentDedicatedNodes))
+
+					if pool.PoolProperties.CurrentLowPriorityNodes != nil {
+						nextBatchPoolsLowPriorityNodes.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(float64(*pool.PoolProperties.CurrentLowPriorityNodes))
 					}
 
-					nextBatchPoolsDedicatedNodes.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(float64(*pool.PoolProperties.CurrentDedicatedNodes))
+					// Scaling
+					targetDedicatedNodes, targetLowPriorityNodes := poolTargetNodes(&pool)
+
+					if targetDedicatedNodes != nil {
+						nextBatchPoolsTargetDedicatedNodes.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(float64(*targetDedicatedNodes))
+					}
+
+					if targetLowPriorityNodes != nil {
+						nextBatchPoolsTargetLowPriorityNodes.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(float64(*targetLowPriorityNodes))
+					}
+
+					if pool.ScaleSettings != nil && pool.ScaleSettings.AutoScale != nil {
+						nextBatchPoolsAutoScaleEnabled.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(1)
+					} else {
+						nextBatchPoolsAutoScaleEnabled.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(0)
+					}
+
+					if pool.AutoScaleRun != nil {
+						if pool.AutoScaleRun.EvaluationTime != nil {
+							nextBatchPoolsAutoScaleEvaluationTime.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(float64(pool.AutoScaleRun.EvaluationTime.Unix()))
+						}
+
+						if pool.AutoScaleRun.Error != nil && pool.AutoScaleRun.Error.Code != nil {
+							nextBatchPoolsAutoScaleError.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name, *pool.AutoScaleRun.Error.Code).Set(1)
+						}
+					}
+
+					if pool.ResizeOperationStatus != nil && pool.ResizeOperationStatus.Errors != nil {
+						for _, resizeError := range *pool.ResizeOperationStatus.Errors {
+							if resizeError.Code != nil {
+								nextBatchPoolsResizeErrors.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name, *resizeError.Code).Inc()
+							}
+						}
+					}
+
+					if startTime, ok := poolResizeStartTime(&pool); ok {
+						nextBatchPoolsResizeOperationAge.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(time.Since(startTime).Seconds())
+					}
+
+					// Info
+					nextBatchPoolsInfo.WithLabelValues(append([]string{*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name}, poolInfoLabels(&pool)...)...).Set(1)
+
+					if pool.CreationTime != nil {
+						nextBatchPoolsCreationTime.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name).Set(float64(pool.CreationTime.Unix()))
+					}
 
 					// Metadata
 					if pool.Metadata != nil {
@@ -318,13 +1045,23 @@ func UpdateBatchMetrics(ctx context.Context) error {
 						}
 					}
 
-					nodes, err := azure.ListBatchComputeNodes(ctx, azureClients, sub, account, &pool)
+					// Nodes state, only when the pool node counts are not used, and
+					// node metrics
+					if listComputeNodes() || nodeMetrics() {
+						nodes, err := azure.ListBatchComputeNodes(ctx, azureClients, sub, account, &pool)
+						poolLabels := []string{*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name}
 
-					if err != nil {
-						accountLogger.WithFields(log.Fields{}).Error(err.Error())
-					} else {
-						for _, node := range *nodes {
-							nextBatchPoolsNodesState.WithLabelValues(*sub.DisplayName, accountProperties.ResourceGroup, *account.Name, *pool.Name, string(node.State)).Inc()
+						if err != nil {
+							accountLogger.WithFields(log.Fields{}).Error(err.Error())
+						} else {
+							if listComputeNodes() {
+								dedicated, lowPriority := computeNodesByState(*nodes)
+								nextBatchPoolsNodesStates.set(poolLabels, dedicated, lowPriority)
+							}
+
+							if nodeMetrics() {
+								nextBatchNodes.set(poolLabels, *nodes, maxNodesPerPool())
+							}
 						}
 					}
 
@@ -337,6 +1074,19 @@ func UpdateBatchMetrics(ctx context.Context) error {
 					wg.Done()
 				}(&(*batchAccounts)[i], pool)
 			}
+
+			// Nodes state of all the pools in one call
+			if !listComputeNodes() {
+				poolNodeCounts, err := azure.ListBatchAccountPoolNodeCounts(ctx, azureClients, sub, &(*batchAccounts)[i])
+
+				if err != nil {
+					accountLogger.Errorf("Unable to list account `%s` pool node counts: %s", *(*batchAccounts)[i].Name, err)
+				} else {
+					for _, counts := range poolNodeCounts {
+						nextBatchPoolsNodesStates.set([]string{*sub.DisplayName, accountProperties.ResourceGroup, *(*batchAccounts)[i].Name, *counts.PoolID}, nodeCountsByState(counts.Dedicated), nodeCountsByState(counts.LowPriority))
+					}
+				}
+			}
 		}
 
 		// -- JOBS -------------------------------------------------------------
@@ -413,12 +1163,26 @@ func UpdateBatchMetrics(ctx context.Context) error {
 
 	wg.Wait()
 
+	pruneBatchPoolPreemptions(seenPools, failedAccounts)
+
 	// swapping current registered metrics with updated copies
 	mu.Lock()
 	*batchPoolQuota = *nextBatchPoolQuota
 	*batchDedicatedCoreQuota = *nextBatchDedicatedCoreQuota
 	*batchPoolsDedicatedNodes = *nextBatchPoolsDedicatedNodes
 	*batchPoolsNodesState = *nextBatchPoolsNodesState
+	*batchPoolsLowPriorityNodes = *nextBatchPoolsLowPriorityNodes
+	*batchPoolsTargetLowPriorityNodes = *nextBatchPoolsTargetLowPriorityNodes
+	*batchPoolsDedicatedNodesState = *nextBatchPoolsDedicatedNodesState
+	*batchPoolsLowPriorityNodesState = *nextBatchPoolsLowPriorityNodesState
+	*batchPoolsTargetDedicatedNodes = *nextBatchPoolsTargetDedicatedNodes
+	*batchPoolsAutoScaleEnabled = *nextBatchPoolsAutoScaleEnabled
+	*batchPoolsAutoScaleEvaluationTime = *nextBatchPoolsAutoScaleEvaluationTime
+	*batchPoolsAutoScaleError = *nextBatchPoolsAutoScaleError
+	*batchPoolsResizeErrors = *nextBatchPoolsResizeErrors
+	*batchPoolsResizeOperationAge = *nextBatchPoolsResizeOperationAge
+	*batchPoolsInfo = *nextBatchPoolsInfo
+	*batchPoolsCreationTime = *nextBatchPoolsCreationTime
 	*batchPoolsAllocationState = *nextBatchPoolsAllocationState
 	*batchPoolsMetadata = *nextBatchPoolsMetadata
 	*batchJobsTasksActive = *nextBatchJobsTasksActive
@@ -429,6 +1193,16 @@ func UpdateBatchMetrics(ctx context.Context) error {
 	*batchJobsInfo = *nextBatchJobsInfo
 	*batchJobsStates = *nextBatchJobsStates
 	*batchJobsMetadata = *nextBatchJobsMetadata
+	*batchNodesState = *nextBatchNodesState
+	*batchNodesRunningTasks = *nextBatchNodesRunningTasks
+	*batchNodesTasksRun = *nextBatchNodesTasksRun
+	*batchNodesTasksSucceeded = *nextBatchNodesTasksSucceeded
+	*batchNodesStartTaskState = *nextBatchNodesStartTaskState
+	*batchNodesStartTaskExitCode = *nextBatchNodesStartTaskExitCode
+	*batchNodesErrors = *nextBatchNodesErrors
+	*batchNodesAgentInfo = *nextBatchNodesAgentInfo
+	*batchNodesUptime = *nextBatchNodesUptime
+	*batchPoolsSkippedNodes = *nextBatchPoolsSkippedNodes
 	mu.Unlock()
 
 	return err
@@ -0,0 +1,502 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/batch/2019-08-01.10.0/batch"
+	azurebatch "github.com/Azure/azure-sdk-for-go/services/batch/mgmt/2019-08-01/batch"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestNodeCountsByState(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts *batch.NodeCounts
+		want   map[batch.ComputeNodeState]int32
+	}{
+		{
+			name:   "nil",
+			counts: nil,
+			want:   map[batch.ComputeNodeState]int32{},
+		},
+		{
+			name:   "empty",
+			counts: &batch.NodeCounts{},
+			want:   map[batch.ComputeNodeState]int32{},
+		},
+		{
+			name: "every state",
+			counts: &batch.NodeCounts{
+				Creating:            int32Ptr(1),
+				Idle:                int32Ptr(2),
+				LeavingPool:         int32Ptr(3),
+				Offline:             int32Ptr(4),
+				Preempted:           int32Ptr(5),
+				Rebooting:           int32Ptr(6),
+				Reimaging:           int32Ptr(7),
+				Running:             int32Ptr(8),
+				Starting:            int32Ptr(9),
+				StartTaskFailed:     int32Ptr(10),
+				Unknown:             int32Ptr(11),
+				Unusable:            int32Ptr(12),
+				WaitingForStartTask: int32Ptr(13),
+				Total:               int32Ptr(91),
+			},
+			want: map[batch.ComputeNodeState]int32{
+				batch.Creating:            1,
+				batch.Idle:                2,
+				batch.LeavingPool:         3,
+				batch.Offline:             4,
+				batch.Preempted:           5,
+				batch.Rebooting:           6,
+				batch.Reimaging:           7,
+				batch.Running:             8,
+				batch.Starting:            9,
+				batch.StartTaskFailed:     10,
+				batch.Unknown:             11,
+				batch.Unusable:            12,
+				batch.WaitingForStartTask: 13,
+			},
+		},
+		{
+			name: "zero counts are kept",
+			counts: &batch.NodeCounts{
+				Idle:    int32Ptr(0),
+				Running: int32Ptr(4),
+			},
+			want: map[batch.ComputeNodeState]int32{
+				batch.Idle:    0,
+				batch.Running: 4,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := nodeCountsByState(test.counts); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("nodeCountsByState() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestComputeNodesByState(t *testing.T) {
+	tests := []struct {
+		name            string
+		nodes           []batch.ComputeNode
+		wantDedicated   map[batch.ComputeNodeState]int32
+		wantLowPriority map[batch.ComputeNodeState]int32
+	}{
+		{
+			name:            "no nodes",
+			nodes:           nil,
+			wantDedicated:   map[batch.ComputeNodeState]int32{},
+			wantLowPriority: map[batch.ComputeNodeState]int32{},
+		},
+		{
+			name: "dedicated and low priority",
+			nodes: []batch.ComputeNode{
+				{State: batch.Idle, IsDedicated: boolPtr(true)},
+				{State: batch.Running, IsDedicated: boolPtr(true)},
+				{State: batch.Running, IsDedicated: boolPtr(true)},
+				{State: batch.Running, IsDedicated: boolPtr(false)},
+				{State: batch.Preempted, IsDedicated: boolPtr(false)},
+			},
+			wantDedicated: map[batch.ComputeNodeState]int32{
+				batch.Idle:    1,
+				batch.Running: 2,
+			},
+			wantLowPriority: map[batch.ComputeNodeState]int32{
+				batch.Running:   1,
+				batch.Preempted: 1,
+			},
+		},
+		{
+			name: "unknown priority counts as low priority",
+			nodes: []batch.ComputeNode{
+				{State: batch.Starting},
+			},
+			wantDedicated: map[batch.ComputeNodeState]int32{},
+			wantLowPriority: map[batch.ComputeNodeState]int32{
+				batch.Starting: 1,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dedicated, lowPriority := computeNodesByState(test.nodes)
+
+			if !reflect.DeepEqual(dedicated, test.wantDedicated) {
+				t.Errorf("dedicated = %v, want %v", dedicated, test.wantDedicated)
+			}
+
+			if !reflect.DeepEqual(lowPriority, test.wantLowPriority) {
+				t.Errorf("low priority = %v, want %v", lowPriority, test.wantLowPriority)
+			}
+		})
+	}
+}
+
+func TestBatchPoolPreemptions(t *testing.T) {
+	kept := []string{"sub", "rg", "account", "kept"}
+	deleted := []string{"sub", "rg", "account", "deleted"}
+	failed := []string{"sub", "rg", "failed", "pool"}
+
+	t.Cleanup(func() {
+		for _, labels := range [][]string{kept, deleted, failed} {
+			batchPoolsPreemptions.DeleteLabelValues(labels...)
+		}
+
+		batchPoolsPreemptedMu.Lock()
+		batchPoolsPreemptedLast = make(map[string]batchPoolPreempted)
+		batchPoolsPreemptedMu.Unlock()
+	})
+
+	// The first run only records the preempted nodes.
+	observeBatchPoolPreemptions(kept, 2)
+	observeBatchPoolPreemptions(deleted, 1)
+	observeBatchPoolPreemptions(failed, 1)
+
+	if got := testutil.ToFloat64(batchPoolsPreemptions.WithLabelValues(kept...)); got != 0 {
+		t.Errorf("preemptions after the first run = %v, want 0", got)
+	}
+
+	observeBatchPoolPreemptions(kept, 5)
+	observeBatchPoolPreemptions(kept, 3)
+
+	if got := testutil.ToFloat64(batchPoolsPreemptions.WithLabelValues(kept...)); got != 3 {
+		t.Errorf("preemptions = %v, want 3", got)
+	}
+
+	pruneBatchPoolPreemptions(map[string]bool{"sub/rg/account/kept": true}, map[string]bool{"sub/rg/failed": true})
+
+	batchPoolsPreemptedMu.Lock()
+	_, deletedKept := batchPoolsPreemptedLast["sub/rg/account/deleted"]
+	_, failedKept := batchPoolsPreemptedLast["sub/rg/failed/pool"]
+	_, keptKept := batchPoolsPreemptedLast["sub/rg/account/kept"]
+	batchPoolsPreemptedMu.Unlock()
+
+	if deletedKept || !failedKept || !keptKept {
+		t.Errorf("pools kept after pruning: deleted %v, failed %v, kept %v", deletedKept, failedKept, keptKept)
+	}
+
+	if got := testutil.CollectAndCount(batchPoolsPreemptions); got != 2 {
+		t.Errorf("preemptions series = %d, want 2", got)
+	}
+}
+
+func TestPoolTargetNodes(t *testing.T) {
+	tests := []struct {
+		name            string
+		pool            azurebatch.Pool
+		wantDedicated   *int32
+		wantLowPriority *int32
+	}{
+		{
+			name: "no properties",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{}},
+		},
+		{
+			name: "fixed scale",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				ScaleSettings: &azurebatch.ScaleSettings{
+					FixedScale: &azurebatch.FixedScaleSettings{TargetDedicatedNodes: int32Ptr(4), TargetLowPriorityNodes: int32Ptr(2)},
+				},
+				ResizeOperationStatus: &azurebatch.ResizeOperationStatus{TargetDedicatedNodes: int32Ptr(8), TargetLowPriorityNodes: int32Ptr(6)},
+			}},
+			wantDedicated:   int32Ptr(4),
+			wantLowPriority: int32Ptr(2),
+		},
+		{
+			name: "auto scale",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				ScaleSettings: &azurebatch.ScaleSettings{
+					AutoScale: &azurebatch.AutoScaleSettings{},
+				},
+				ResizeOperationStatus: &azurebatch.ResizeOperationStatus{TargetDedicatedNodes: int32Ptr(8), TargetLowPriorityNodes: int32Ptr(6)},
+			}},
+			wantDedicated:   int32Ptr(8),
+			wantLowPriority: int32Ptr(6),
+		},
+		{
+			name: "auto scale without resize",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				ScaleSettings: &azurebatch.ScaleSettings{
+					AutoScale: &azurebatch.AutoScaleSettings{},
+				},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dedicated, lowPriority := poolTargetNodes(&test.pool)
+
+			if !reflect.DeepEqual(dedicated, test.wantDedicated) {
+				t.Errorf("dedicated = %v, want %v", dedicated, test.wantDedicated)
+			}
+
+			if !reflect.DeepEqual(lowPriority, test.wantLowPriority) {
+				t.Errorf("low priority = %v, want %v", lowPriority, test.wantLowPriority)
+			}
+		})
+	}
+}
+
+func TestPoolResizeStartTime(t *testing.T) {
+	started := date.Time{Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	transitioned := date.Time{Time: time.Date(2020, 1, 2, 3, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name   string
+		pool   azurebatch.Pool
+		want   time.Time
+		wantOK bool
+	}{
+		{
+			name: "steady",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				AllocationState:               azurebatch.Steady,
+				AllocationStateTransitionTime: &transitioned,
+				ResizeOperationStatus:         &azurebatch.ResizeOperationStatus{StartTime: &started},
+			}},
+		},
+		{
+			name: "stopping",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				AllocationState:       azurebatch.Stopping,
+				ResizeOperationStatus: &azurebatch.ResizeOperationStatus{StartTime: &started},
+			}},
+		},
+		{
+			name: "resizing",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				AllocationState:               azurebatch.Resizing,
+				AllocationStateTransitionTime: &transitioned,
+				ResizeOperationStatus:         &azurebatch.ResizeOperationStatus{StartTime: &started},
+			}},
+			want:   started.Time,
+			wantOK: true,
+		},
+		{
+			name: "resizing without resize operation",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				AllocationState:               azurebatch.Resizing,
+				AllocationStateTransitionTime: &transitioned,
+			}},
+			want:   transitioned.Time,
+			wantOK: true,
+		},
+		{
+			name: "resizing without any time",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				AllocationState:       azurebatch.Resizing,
+				ResizeOperationStatus: &azurebatch.ResizeOperationStatus{},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := poolResizeStartTime(&test.pool)
+
+			if ok != test.wantOK || !got.Equal(test.want) {
+				t.Errorf("poolResizeStartTime() = %v, %v, want %v, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestPoolInfoLabels(t *testing.T) {
+	vmSize := "STANDARD_D2_V3"
+	publisher := "microsoft-azure-batch"
+	offer := "ubuntu-server-container"
+	sku := "16-04-lts"
+	version := "latest"
+	nodeAgentSku := "batch.node.ubuntu 16.04"
+	subnetID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+
+	tests := []struct {
+		name string
+		pool azurebatch.Pool
+		want []string
+	}{
+		{
+			name: "empty",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{}},
+			want: []string{"", "", "", "", "", "", "", "", ""},
+		},
+		{
+			name: "nil network configuration",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				VMSize:          &vmSize,
+				MaxTasksPerNode: int32Ptr(4),
+				DeploymentConfiguration: &azurebatch.DeploymentConfiguration{
+					VirtualMachineConfiguration: &azurebatch.VirtualMachineConfiguration{
+						NodeAgentSkuID: &nodeAgentSku,
+					},
+				},
+				InterNodeCommunication: azurebatch.Disabled,
+			}},
+			want: []string{vmSize, "", "", "", "", nodeAgentSku, "4", "Disabled", ""},
+		},
+		{
+			name: "full",
+			pool: azurebatch.Pool{PoolProperties: &azurebatch.PoolProperties{
+				VMSize:          &vmSize,
+				MaxTasksPerNode: int32Ptr(1),
+				DeploymentConfiguration: &azurebatch.DeploymentConfiguration{
+					VirtualMachineConfiguration: &azurebatch.VirtualMachineConfiguration{
+						ImageReference: &azurebatch.ImageReference{
+							Publisher: &publisher,
+							Offer:     &offer,
+							Sku:       &sku,
+							Version:   &version,
+						},
+						NodeAgentSkuID: &nodeAgentSku,
+					},
+				},
+				InterNodeCommunication: azurebatch.Enabled,
+				NetworkConfiguration: &azurebatch.NetworkConfiguration{
+					SubnetID: &subnetID,
+				},
+			}},
+			want: []string{vmSize, publisher, offer, sku, version, nodeAgentSku, "1", "Enabled", subnetID},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := poolInfoLabels(&test.pool)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("poolInfoLabels() = %q, want %q", got, test.want)
+			}
+
+			if _, err := newBatchPoolsInfo().GetMetricWithLabelValues(append([]string{"sub", "rg", "account", "pool"}, got...)...); err != nil {
+				t.Errorf("labels do not match azure_batch_pool_info: %v", err)
+			}
+		})
+	}
+}
+
+func newTestBatchNodes() batchNodes {
+	return batchNodes{
+		state:          newBatchNodesState(),
+		runningTasks:   newBatchNodesRunningTasks(),
+		tasksRun:       newBatchNodesTasksRun(),
+		tasksSucceeded: newBatchNodesTasksSucceeded(),
+		startTaskState: newBatchNodesStartTaskState(),
+		startTaskExit:  newBatchNodesStartTaskExitCode(),
+		errors:         newBatchNodesErrors(),
+		agentInfo:      newBatchNodesAgentInfo(),
+		uptime:         newBatchNodesUptime(),
+		skippedNodes:   newBatchPoolsSkippedNodes(),
+	}
+}
+
+// gatheredNodes returns the node label values of the series of vec.
+func gatheredNodes(t *testing.T, vec prometheus.Collector) []string {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(vec)
+
+	families, err := registry.Gather()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := []string{}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "node" {
+					nodes = append(nodes, label.GetValue())
+				}
+			}
+		}
+	}
+
+	return nodes
+}
+
+func TestBatchNodesSet(t *testing.T) {
+	id := func(s string) *string {
+		return &s
+	}
+
+	nodes := []batch.ComputeNode{
+		{ID: id("a"), State: batch.Running},
+		{ID: id("b"), State: batch.Idle},
+		{ID: nil, State: batch.Unusable},
+		{ID: id("d"), State: batch.StartTaskFailed},
+		{ID: id("c"), State: batch.Unusable},
+	}
+
+	tests := []struct {
+		name        string
+		maxNodes    int
+		wantNodes   []string
+		wantSkipped float64
+	}{
+		{
+			name:        "unhealthy nodes first",
+			maxNodes:    2,
+			wantNodes:   []string{"c", "d"},
+			wantSkipped: 3,
+		},
+		{
+			name:        "sorted by id",
+			maxNodes:    1,
+			wantNodes:   []string{"c"},
+			wantSkipped: 4,
+		},
+		{
+			name:        "nodes without id are skipped",
+			maxNodes:    100,
+			wantNodes:   []string{"a", "b", "c", "d"},
+			wantSkipped: 1,
+		},
+		{
+			name:        "no nodes",
+			maxNodes:    0,
+			wantNodes:   []string{},
+			wantSkipped: 5,
+		},
+	}
+
+	labels := []string{"sub", "rg", "account", "pool"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBatchNodes()
+			b.set(labels, nodes, test.maxNodes)
+
+			if got := gatheredNodes(t, b.state); !reflect.DeepEqual(got, test.wantNodes) {
+				t.Errorf("nodes = %v, want %v", got, test.wantNodes)
+			}
+
+			if got := testutil.ToFloat64(b.skippedNodes.WithLabelValues(labels...)); got != test.wantSkipped {
+				t.Errorf("skipped nodes = %v, want %v", got, test.wantSkipped)
+			}
+		})
+	}
+
+	if *nodes[0].ID != "a" {
+		t.Error("set() must not reorder the given nodes")
+	}
+}
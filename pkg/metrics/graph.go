@@ -48,8 +48,8 @@ func newGraphApplicationPasswordExpire() *prometheus.GaugeVec {
 // -----------------------------------------------------------------------------
 
 func init() {
-	prometheus.MustRegister(graphApplicationKeyExpire)
-	prometheus.MustRegister(graphApplicationPasswordExpire)
+	registries[moduleGraph].MustRegister(graphApplicationKeyExpire)
+	registries[moduleGraph].MustRegister(graphApplicationPasswordExpire)
 
 	if GetUpdateMetricsFunctionInterval("graph") == nil {
 		RegisterUpdateMetricsFunctionWithInterval("graph", UpdateGraphMetrics, 60*time.Second)
@@ -70,7 +70,7 @@ func UpdateGraphMetrics(ctx context.Context) error {
 	nextGraphApplicationPasswordExpire := newGraphApplicationPasswordExpire()
 
 	// <!-- APPLICATIONS -------------------------------------------------------
-	azureClients := azure.NewAzureClients()
+	azureClients := azure.AzureClientsFromContext(ctx)
 	applications, err := azure.ListApplications(ctx, azureClients)
 
 	if err != nil {
@@ -78,6 +78,9 @@ func UpdateGraphMetrics(ctx context.Context) error {
 		return err
 	}
 
+	// The exporter application is part of the list if it can read it.
+	azure.ObserveCredentialSecretExpiry(applications)
+
 	for _, app := range *applications {
 		for _, key := range *app.KeyCredentials {
 			var decodedName string
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sylr/prometheus-azure-exporter/pkg/azure"
+)
+
+var (
+	// Mutex used to lock read/writes of intervalHeartbeats/updateMetricsFunctionsLastSuccess.
+	healthMutex = sync.RWMutex{}
+	// This var holds the last time each interval process has been seen alive.
+	intervalHeartbeats = make(map[time.Duration]time.Time)
+	// This var holds the last time each update metrics function ended without error.
+	updateMetricsFunctionsLastSuccess = make(map[string]time.Time)
+	// Grace period added to the interval before considering an interval process dead.
+	heartbeatGracePeriod = time.Minute
+)
+
+// heartbeat records that the process of the given interval is alive.
+func heartbeat(interval time.Duration) {
+	healthMutex.Lock()
+	intervalHeartbeats[interval] = time.Now()
+	healthMutex.Unlock()
+}
+
+// markUpdateMetricsFunctionSuccess records a successful run of an update metrics function.
+func markUpdateMetricsFunctionSuccess(name string) {
+	healthMutex.Lock()
+	updateMetricsFunctionsLastSuccess[name] = time.Now()
+	healthMutex.Unlock()
+}
+
+// Alive returns an error if one of the interval processes which should be
+// running has not been seen alive for more than its interval.
+func Alive() error {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	healthMutex.RLock()
+	defer healthMutex.RUnlock()
+
+	for interval, functions := range intervalUpdateMetricsFunctions {
+		if len(functions) == 0 {
+			continue
+		}
+
+		last, ok := intervalHeartbeats[interval]
+
+		if !ok {
+			return fmt.Errorf("interval process %s has not started", interval)
+		}
+
+		if since := time.Since(last); since > interval+heartbeatGracePeriod {
+			return fmt.Errorf("interval process %s has not been seen alive for %v", interval, since.Round(time.Second))
+		}
+	}
+
+	return nil
+}
+
+// Ready returns an error until all the registered update metrics functions
+// have run successfully at least once, or if authentication against Azure
+// is failing.
+func Ready() error {
+	if err := azure.AuthenticationError(); err != nil {
+		return fmt.Errorf("azure authentication is failing: %s", err)
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	healthMutex.RLock()
+	defer healthMutex.RUnlock()
+
+	for _, functions := range intervalUpdateMetricsFunctions {
+		for name := range functions {
+			if _, ok := updateMetricsFunctionsLastSuccess[name]; !ok {
+				return fmt.Errorf("update metrics function `%s` has not run successfully yet", name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sylr/prometheus-azure-exporter/pkg/azure"
+)
+
+// setHealthState replaces the registered update metrics functions and the
+// health state for the duration of the test.
+func setHealthState(t *testing.T, functions map[time.Duration]map[string]UpdateMetricsFunction) {
+	mutex.Lock()
+	healthMutex.Lock()
+	previousFunctions := intervalUpdateMetricsFunctions
+	previousHeartbeats := intervalHeartbeats
+	previousSuccesses := updateMetricsFunctionsLastSuccess
+	intervalUpdateMetricsFunctions = functions
+	intervalHeartbeats = make(map[time.Duration]time.Time)
+	updateMetricsFunctionsLastSuccess = make(map[string]time.Time)
+	healthMutex.Unlock()
+	mutex.Unlock()
+
+	t.Cleanup(func() {
+		mutex.Lock()
+		healthMutex.Lock()
+		intervalUpdateMetricsFunctions = previousFunctions
+		intervalHeartbeats = previousHeartbeats
+		updateMetricsFunctionsLastSuccess = previousSuccesses
+		healthMutex.Unlock()
+		mutex.Unlock()
+	})
+}
+
+func noopUpdateMetricsFunction(ctx context.Context) error {
+	return nil
+}
+
+func TestAlive(t *testing.T) {
+	interval := 30 * time.Second
+
+	setHealthState(t, map[time.Duration]map[string]UpdateMetricsFunction{
+		interval:    {"batch": noopUpdateMetricsFunction},
+		time.Minute: {},
+	})
+
+	if err := Alive(); err == nil {
+		t.Error("expected an error before the interval process started")
+	}
+
+	heartbeat(interval)
+
+	if err := Alive(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	healthMutex.Lock()
+	intervalHeartbeats[interval] = time.Now().Add(-interval - heartbeatGracePeriod - time.Second)
+	healthMutex.Unlock()
+
+	if err := Alive(); err == nil {
+		t.Error("expected an error when the interval process has not been seen for too long")
+	}
+}
+
+func TestReady(t *testing.T) {
+	setHealthState(t, map[time.Duration]map[string]UpdateMetricsFunction{
+		30 * time.Second: {"batch": noopUpdateMetricsFunction, "graph": noopUpdateMetricsFunction},
+	})
+
+	azure.InvalidateAuthorizers()
+
+	markUpdateMetricsFunctionSuccess("batch")
+
+	if err := Ready(); err == nil {
+		t.Error("expected an error until every function has run successfully")
+	}
+
+	markUpdateMetricsFunctionSuccess("graph")
+
+	if err := Ready(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	// Authentication fails with an unknown credential.
+	t.Setenv("AZURE_CREDENTIAL", "unknown")
+	azure.ResetCredential()
+
+	t.Cleanup(func() {
+		azure.ResetCredential()
+		azure.InvalidateAuthorizers()
+	})
+
+	if _, err := azure.GetAuthorizerWithResource("https://storage.azure.com/"); err == nil {
+		t.Fatal("expected an authentication error")
+	}
+
+	if err := Ready(); err == nil {
+		t.Error("expected an error while authentication is failing")
+	}
+}
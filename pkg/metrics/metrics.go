@@ -5,11 +5,18 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/azure"
+	"github.com/sylr/prometheus-azure-exporter/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -77,12 +84,20 @@ var (
 	// This var holds all the cancel functions of the contexts used by
 	// the interval processes.
 	intervalCancelFunctions = make(map[time.Duration]context.CancelFunc)
+	// This var holds the hooks called after each successful run of an
+	// update metrics function.
+	updateMetricsFunctionHooks = make([]UpdateMetricsFunctionHook, 0)
 )
 
 // UpdateMetricsFunction is the function type which needs to respected to
 // create update metrics functions.
 type UpdateMetricsFunction func(context.Context) error
 
+// UpdateMetricsFunctionHook is the function type of the hooks called after
+// each successful run of an update metrics function. It receives the module
+// the function belongs to and the gatherer of that module.
+type UpdateMetricsFunctionHook func(ctx context.Context, module string, gatherer prometheus.Gatherer)
+
 // initUpdateMetricsFunctionsMap makes sure the map is initialized.
 func initUpdateMetricsFunctionsMap(interval time.Duration) {
 	if intervalUpdateMetricsFunctions[interval] == nil {
@@ -129,6 +144,15 @@ func RegisterUpdateMetricsFunctionWithInterval(name string, f UpdateMetricsFunct
 	intervalUpdateMetricsFunctions[interval][name] = f
 }
 
+// RegisterUpdateMetricsFunctionHook allows you to register a function that
+// will be called after each successful run of an update metrics function.
+func RegisterUpdateMetricsFunctionHook(hook UpdateMetricsFunctionHook) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	updateMetricsFunctionHooks = append(updateMetricsFunctionHooks, hook)
+}
+
 // GetUpdateMetricsFunction returns the update metrics function associated to `name`.
 // It will only return a result if the function has previously been registered once.
 // It does not matter if the function has been un-registered.
@@ -214,6 +238,7 @@ func updateMetricsWithInterval(ctx context.Context, wg *sync.WaitGroup, interval
 	})
 
 	processLogger.Infof("Start interval update metrics process: %s", interval)
+	heartbeat(interval)
 
 	// Aligning update metric processes with minute start
 	sec := int64(interval/time.Second) - (time.Now().Unix() % int64(interval/time.Second))
@@ -234,36 +259,22 @@ func updateMetricsWithInterval(ctx context.Context, wg *sync.WaitGroup, interval
 	t = time.Now()
 
 	for {
+		heartbeat(interval)
+
 		// Loop over all update metrics functions
 		for updateMetricsFuncName, updateMetricsFunc := range intervalUpdateMetricsFunctions[interval] {
 			updateMetricsFunctionIntervalDurationGauge.WithLabelValues(updateMetricsFuncName).Set(float64(interval.Seconds()))
 
+			// Spare the API calls budget shared with other Azure clients
+			if state, ok := azure.AllowUpdateMetricsFunctionRun(updateMetricsFuncName); !ok {
+				processLogger.Warnf("Function `%s` skipped, remaining Azure API calls are low (throttling: %s)", updateMetricsFuncName, state)
+				continue
+			}
+
 			// We detach the update process so that if it takes more than the refresh
 			// time it does not get blocked
 			go func(ctx context.Context, updateMetricsFuncName string, updateMetricsFunc UpdateMetricsFunction, t time.Time) {
-				id := processHash(t, updateMetricsFuncName)
-				functionLogger := processLogger.WithFields(log.Fields{
-					"_id":       id,
-					"_interval": interval,
-					"_func":     updateMetricsFuncName,
-				})
-
-				ctx = context.WithValue(ctx, "id", id)
-
-				functionLogger.Debugf("Start update metrics function")
-
-				// Run update metrics function
-				t0 := time.Now()
-				err := updateMetricsFunc(ctx)
-				t1 := time.Since(t0)
-
-				// metrics
-				if err == nil {
-					updateMetricsFunctionDurationHistogram.WithLabelValues(updateMetricsFuncName).Observe(t1.Seconds())
-					updateMetricsFunctionLastDurationGauge.WithLabelValues(updateMetricsFuncName).Set(t1.Seconds())
-				}
-
-				functionLogger.Debugf("End update metrics function in %v", t1.Round(time.Millisecond))
+				t1, _ := runUpdateMetricsFunction(ctx, processLogger, updateMetricsFuncName, updateMetricsFunc, t)
 
 				// Warning if update metrics function takes more time than the
 				// interval it is registered with.
@@ -287,8 +298,129 @@ done:
 	wg.Done()
 }
 
+// RunUpdateMetricsFunctionsOnce runs the given update metrics functions once
+// and waits for them to end. If no name is given, all the functions currently
+// registered with an interval are run. It returns an error if at least one
+// of the functions failed.
+func RunUpdateMetricsFunctionsOnce(ctx context.Context, names []string) error {
+	functions := make(map[string]UpdateMetricsFunction)
+
+	mutex.RLock()
+	if len(names) == 0 {
+		for interval := range intervalUpdateMetricsFunctions {
+			for name, f := range intervalUpdateMetricsFunctions[interval] {
+				functions[name] = f
+			}
+		}
+	} else {
+		for _, name := range names {
+			f, ok := updateMetricsFunctions[name]
+
+			if !ok {
+				mutex.RUnlock()
+				return fmt.Errorf("unknown update metrics function `%s`", name)
+			}
+
+			functions[name] = f
+		}
+	}
+	mutex.RUnlock()
+
+	processLogger := log.WithFields(log.Fields{
+		"_id": "00000000",
+	})
+
+	t := time.Now()
+	wg := sync.WaitGroup{}
+	failedMutex := sync.Mutex{}
+	failed := make([]string, 0)
+
+	for name, f := range functions {
+		wg.Add(1)
+
+		go func(name string, f UpdateMetricsFunction) {
+			defer wg.Done()
+
+			if _, err := runUpdateMetricsFunction(ctx, processLogger, name, f, t); err != nil {
+				failedMutex.Lock()
+				failed = append(failed, name)
+				failedMutex.Unlock()
+			}
+		}(name, f)
+	}
+
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("update metrics functions failed: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// runUpdateMetricsFunction runs an update metrics function with a context
+// holding the process id and records its duration.
+func runUpdateMetricsFunction(ctx context.Context, processLogger *log.Entry, name string, f UpdateMetricsFunction, t time.Time) (time.Duration, error) {
+	id := processHash(t, name)
+	functionLogger := processLogger.WithFields(log.Fields{
+		"_id":   id,
+		"_func": name,
+	})
+
+	ctx = context.WithValue(ctx, "id", id)
+	ctx = context.WithValue(ctx, "func", name)
+	ctx = azure.ContextWithAzureClients(ctx, azure.GetAzureClients())
+	ctx = azure.ContextWithInventory(ctx)
+	ctx, span := tracing.Tracer().Start(ctx, "UpdateMetricsFunction "+name,
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("exporter.function", name),
+			attribute.String("exporter.process_id", id),
+		),
+	)
+
+	functionLogger.Debugf("Start update metrics function")
+
+	// Run update metrics function
+	t0 := time.Now()
+	err := f(ctx)
+	t1 := time.Since(t0)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+
+	// metrics
+	if err == nil {
+		markUpdateMetricsFunctionSuccess(name)
+		updateMetricsFunctionDurationHistogram.WithLabelValues(name).Observe(t1.Seconds())
+		updateMetricsFunctionLastDurationGauge.WithLabelValues(name).Set(t1.Seconds())
+	}
+
+	functionLogger.Debugf("End update metrics function in %v", t1.Round(time.Millisecond))
+
+	// hooks
+	if err == nil {
+		mutex.RLock()
+		hooks := updateMetricsFunctionHooks
+		mutex.RUnlock()
+
+		module, gatherer := moduleGatherer(name)
+
+		for _, hook := range hooks {
+			hook(ctx, module, gatherer)
+		}
+	}
+
+	return t1, err
+}
+
 // processHash generates a hash based on time and salt to be used
-// as id in the logger.
+// as id in the logger and in the spans of the run.
 func processHash(t time.Time, salt string) string {
 	h := md5.New()
 	io.WriteString(h, salt+":"+t.String())
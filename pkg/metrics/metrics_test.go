@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRunUpdateMetricsFunctionSpans(t *testing.T) {
+	setHealthState(t, map[time.Duration]map[string]UpdateMetricsFunction{})
+
+	previous := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	f := func(ctx context.Context) error {
+		_, span := tracing.Tracer().Start(ctx, "call")
+		span.End()
+		return nil
+	}
+
+	// The runs are not parented to the span of the caller.
+	ctx, caller := tracing.Tracer().Start(context.Background(), "caller")
+	logger := log.WithFields(log.Fields{})
+	t0 := time.Now()
+
+	for _, run := range []time.Time{t0, t0.Add(time.Minute)} {
+		if _, err := runUpdateMetricsFunction(ctx, logger, "test", f, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	caller.End()
+
+	roots := map[string]sdktrace.ReadOnlySpan{}
+	processIDs := map[string]bool{}
+	children := []sdktrace.ReadOnlySpan{}
+
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "UpdateMetricsFunction test":
+			if span.Parent().IsValid() {
+				t.Error("expected the update metrics function span to be a root span")
+			}
+
+			for _, attr := range span.Attributes() {
+				if attr.Key == attribute.Key("exporter.process_id") {
+					roots[span.SpanContext().SpanID().String()] = span
+					processIDs[attr.Value.AsString()] = true
+					break
+				}
+			}
+		case "call":
+			children = append(children, span)
+		}
+	}
+
+	if len(roots) != 2 || len(processIDs) != 2 {
+		t.Fatalf("got %d root spans with %d process ids, want one per run", len(roots), len(processIDs))
+	}
+
+	if len(children) != 2 {
+		t.Fatalf("got %d child spans, want 2", len(children))
+	}
+
+	for _, child := range children {
+		if _, ok := roots[child.Parent().SpanID().String()]; !ok {
+			t.Error("expected the child span to be parented to the span of its run")
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"io"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sylr/prometheus-azure-exporter/pkg/azure"
+)
+
+const (
+	moduleAPI     = "api"
+	moduleBatch   = "batch"
+	moduleGraph   = "graph"
+	moduleStorage = "storage"
+)
+
+var (
+	// This var holds the registry of each module so that modules can be
+	// scraped independently at their own pace.
+	registries = map[string]*prometheus.Registry{
+		moduleAPI:     azure.Registry,
+		moduleBatch:   prometheus.NewRegistry(),
+		moduleGraph:   prometheus.NewRegistry(),
+		moduleStorage: prometheus.NewRegistry(),
+	}
+)
+
+var (
+	// This var holds the module each update metrics function belongs to.
+	updateMetricsFunctionModules = map[string]string{
+		"api_rate_limiting": moduleAPI,
+		"batch":             moduleBatch,
+		"graph":             moduleGraph,
+		"storage":           moduleStorage,
+	}
+)
+
+// moduleGatherer returns the module an update metrics function belongs to and
+// the gatherer of that module. Functions which do not belong to a module get
+// the aggregated gatherer.
+func moduleGatherer(name string) (string, prometheus.Gatherer) {
+	if module, ok := updateMetricsFunctionModules[name]; ok {
+		return module, registries[module]
+	}
+
+	return name, Gatherer()
+}
+
+// Modules returns the sorted list of modules owning a registry.
+func Modules() []string {
+	modules := make([]string, 0, len(registries))
+
+	for module := range registries {
+		modules = append(modules, module)
+	}
+
+	sort.Strings(modules)
+
+	return modules
+}
+
+// Registry returns the registry of the given module, nil if the module does
+// not exist.
+func Registry(module string) *prometheus.Registry {
+	return registries[module]
+}
+
+// Gatherer returns a gatherer aggregating the default registry, which holds
+// the exporter metrics, and all the modules registries.
+func Gatherer() prometheus.Gatherer {
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer}
+
+	for _, module := range Modules() {
+		gatherers = append(gatherers, registries[module])
+	}
+
+	return gatherers
+}
+
+// WriteTextfile writes all the gathered metrics in the text format to the
+// given file, the file is written atomically so that it can be collected by
+// the node_exporter textfile collector.
+func WriteTextfile(filename string) error {
+	return prometheus.WriteToTextfile(filename, Gatherer())
+}
+
+// WriteText writes all the gathered metrics in the text format to w.
+func WriteText(w io.Writer) error {
+	mfs, err := Gatherer().Gather()
+
+	if err != nil {
+		return err
+	}
+
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -46,7 +46,7 @@ func newStorageAccountContainerBlobSizeHistogram() *prometheus.HistogramVec {
 // -----------------------------------------------------------------------------
 
 func init() {
-	prometheus.MustRegister(storageAccountContainerBlobSizeHistogram)
+	registries[moduleStorage].MustRegister(storageAccountContainerBlobSizeHistogram)
 
 	if GetUpdateMetricsFunctionInterval("storage") == nil {
 		RegisterUpdateMetricsFunctionWithInterval("storage", UpdateStorageMetrics, 2*time.Hour)
@@ -62,7 +62,7 @@ func UpdateStorageMetrics(ctx context.Context) error {
 		"_func": "UpdateStorageMetrics",
 	})
 
-	azureClients := azure.NewAzureClients()
+	azureClients := azure.AzureClientsFromContext(ctx)
 	sub, err := azure.GetSubscription(ctx, azureClients, os.Getenv("AZURE_SUBSCRIPTION_ID"))
 
 	if err != nil {
@@ -0,0 +1,173 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const (
+	defaultInterval    = time.Minute
+	defaultTimeout     = 30 * time.Second
+	defaultServiceName = "prometheus-azure-exporter"
+)
+
+var (
+	// Mutex used to lock read/writes of currentConfig/provider.
+	mutex = sync.Mutex{}
+	// OTLP config currently applied, nil if the export is disabled.
+	currentConfig *config.OTLPConfig
+	// Meter provider periodically exporting the gathered metrics.
+	provider *sdkmetric.MeterProvider
+)
+
+func init() {
+	// Route errors of the OpenTelemetry SDK, export failures included, to our logger.
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.WithFields(log.Fields{
+			"_id": "00000000",
+		}).Errorf("otlp: %s", err)
+	}))
+}
+
+// ApplyConfig starts exporting the metrics gathered from g to the collector
+// described in conf, a nil conf stops the export. The exporter is only
+// restarted if the config changed.
+func ApplyConfig(conf *config.OTLPConfig, g prometheus.Gatherer) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if reflect.DeepEqual(conf, currentConfig) {
+		return nil
+	}
+
+	var next *sdkmetric.MeterProvider
+
+	if conf != nil {
+		var err error
+		next, err = newMeterProvider(conf, g)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	shutdown(provider)
+	currentConfig = conf
+	provider = next
+
+	return nil
+}
+
+// Shutdown exports the metrics one last time and stops the export.
+func Shutdown() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	shutdown(provider)
+	currentConfig = nil
+	provider = nil
+}
+
+func shutdown(p *sdkmetric.MeterProvider) {
+	if p == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// Errors are reported to the error handler.
+	p.Shutdown(ctx)
+}
+
+func newMeterProvider(conf *config.OTLPConfig, g prometheus.Gatherer) (*sdkmetric.MeterProvider, error) {
+	interval := conf.Interval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	exporter, err := newExporter(conf, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := NewResource(conf.ResourceAttributes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(interval),
+		sdkmetric.WithTimeout(timeout),
+		sdkmetric.WithProducer(&producer{gatherer: g}),
+	)
+
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(reader),
+	), nil
+}
+
+// NewResource returns the resource describing the exporter, attrs are added
+// to the default attributes and can override them.
+func NewResource(attrs map[string]string) (*resource.Resource, error) {
+	kvs := []attribute.KeyValue{attribute.String("service.name", defaultServiceName)}
+	for k, v := range attrs {
+		kvs = append(kvs, attribute.String(k, v))
+	}
+
+	return resource.Merge(resource.Default(), resource.NewSchemaless(kvs...))
+}
+
+func newExporter(conf *config.OTLPConfig, timeout time.Duration) (sdkmetric.Exporter, error) {
+	ctx := context.Background()
+
+	switch conf.Protocol {
+	case "", "grpc":
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(conf.Endpoint),
+			otlpmetricgrpc.WithHeaders(conf.Headers),
+			otlpmetricgrpc.WithTimeout(timeout),
+		}
+
+		if conf.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+
+		return otlpmetricgrpc.New(ctx, opts...)
+	case "http":
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(conf.Endpoint),
+			otlpmetrichttp.WithHeaders(conf.Headers),
+			otlpmetrichttp.WithTimeout(timeout),
+		}
+
+		if conf.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+
+		return otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("otlp: unknown protocol `%s`", conf.Protocol)
+	}
+}
@@ -0,0 +1,204 @@
+package otlp
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const (
+	scopeName = "github.com/sylr/prometheus-azure-exporter"
+)
+
+var (
+	// Prometheus metrics are cumulative since the start of the process.
+	startTime = time.Now()
+)
+
+// producer bridges the metrics gathered from a Prometheus gatherer to the
+// OpenTelemetry SDK so that they can be exported by an OTLP exporter.
+type producer struct {
+	gatherer prometheus.Gatherer
+}
+
+// Produce implements metric.Producer.
+func (p *producer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	mfs, err := p.gatherer.Gather()
+
+	// Gather returns as many metrics as possible even when failing, we do not
+	// want to drop them all because of one collector.
+	if err != nil {
+		log.WithFields(log.Fields{
+			"_id": "00000000",
+		}).Errorf("otlp: error(s) occurred while gathering metrics: %s", err)
+	}
+
+	now := time.Now()
+	metrics := make([]metricdata.Metrics, 0, len(mfs))
+
+	for _, mf := range mfs {
+		if m, ok := convertMetricFamily(mf, now); ok {
+			metrics = append(metrics, m)
+		}
+	}
+
+	if len(metrics) == 0 {
+		return nil, nil
+	}
+
+	return []metricdata.ScopeMetrics{
+		{
+			Scope:   instrumentation.Scope{Name: scopeName},
+			Metrics: metrics,
+		},
+	}, nil
+}
+
+// convertMetricFamily converts a Prometheus metric family, it returns false
+// if the family type is not supported.
+func convertMetricFamily(mf *dto.MetricFamily, now time.Time) (metricdata.Metrics, bool) {
+	m := metricdata.Metrics{
+		Name:        mf.GetName(),
+		Description: mf.GetHelp(),
+	}
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		points := make([]metricdata.DataPoint[float64], 0, len(mf.Metric))
+
+		for _, metric := range mf.Metric {
+			points = append(points, metricdata.DataPoint[float64]{
+				Attributes: attributes(metric),
+				StartTime:  startTime,
+				Time:       timestamp(metric, now),
+				Value:      metric.GetCounter().GetValue(),
+			})
+		}
+
+		m.Data = metricdata.Sum[float64]{
+			DataPoints:  points,
+			Temporality: metricdata.CumulativeTemporality,
+			IsMonotonic: true,
+		}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		points := make([]metricdata.DataPoint[float64], 0, len(mf.Metric))
+
+		for _, metric := range mf.Metric {
+			value := metric.GetGauge().GetValue()
+
+			if mf.GetType() == dto.MetricType_UNTYPED {
+				value = metric.GetUntyped().GetValue()
+			}
+
+			points = append(points, metricdata.DataPoint[float64]{
+				Attributes: attributes(metric),
+				Time:       timestamp(metric, now),
+				Value:      value,
+			})
+		}
+
+		m.Data = metricdata.Gauge[float64]{
+			DataPoints: points,
+		}
+	case dto.MetricType_HISTOGRAM:
+		points := make([]metricdata.HistogramDataPoint[float64], 0, len(mf.Metric))
+
+		for _, metric := range mf.Metric {
+			points = append(points, convertHistogram(metric, now))
+		}
+
+		m.Data = metricdata.Histogram[float64]{
+			DataPoints:  points,
+			Temporality: metricdata.CumulativeTemporality,
+		}
+	case dto.MetricType_SUMMARY:
+		points := make([]metricdata.SummaryDataPoint, 0, len(mf.Metric))
+
+		for _, metric := range mf.Metric {
+			summary := metric.GetSummary()
+			quantiles := make([]metricdata.QuantileValue, 0, len(summary.GetQuantile()))
+
+			for _, q := range summary.GetQuantile() {
+				quantiles = append(quantiles, metricdata.QuantileValue{
+					Quantile: q.GetQuantile(),
+					Value:    q.GetValue(),
+				})
+			}
+
+			points = append(points, metricdata.SummaryDataPoint{
+				Attributes:     attributes(metric),
+				StartTime:      startTime,
+				Time:           timestamp(metric, now),
+				Count:          summary.GetSampleCount(),
+				Sum:            summary.GetSampleSum(),
+				QuantileValues: quantiles,
+			})
+		}
+
+		m.Data = metricdata.Summary{
+			DataPoints: points,
+		}
+	default:
+		return m, false
+	}
+
+	return m, true
+}
+
+// convertHistogram converts Prometheus cumulative buckets to OpenTelemetry
+// buckets which hold the count of their own range only, the last bucket
+// being the overflow one.
+func convertHistogram(metric *dto.Metric, now time.Time) metricdata.HistogramDataPoint[float64] {
+	histogram := metric.GetHistogram()
+	bounds := make([]float64, 0, len(histogram.GetBucket()))
+	counts := make([]uint64, 0, len(histogram.GetBucket())+1)
+
+	var previous uint64
+
+	for _, bucket := range histogram.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), +1) {
+			continue
+		}
+
+		bounds = append(bounds, bucket.GetUpperBound())
+		counts = append(counts, bucket.GetCumulativeCount()-previous)
+		previous = bucket.GetCumulativeCount()
+	}
+
+	counts = append(counts, histogram.GetSampleCount()-previous)
+
+	return metricdata.HistogramDataPoint[float64]{
+		Attributes:   attributes(metric),
+		StartTime:    startTime,
+		Time:         timestamp(metric, now),
+		Count:        histogram.GetSampleCount(),
+		Bounds:       bounds,
+		BucketCounts: counts,
+		Sum:          histogram.GetSampleSum(),
+	}
+}
+
+func attributes(metric *dto.Metric) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(metric.GetLabel()))
+
+	for _, label := range metric.GetLabel() {
+		kvs = append(kvs, attribute.String(label.GetName(), label.GetValue()))
+	}
+
+	return attribute.NewSet(kvs...)
+}
+
+func timestamp(metric *dto.Metric, now time.Time) time.Time {
+	if metric.TimestampMs != nil {
+		return time.Unix(0, metric.GetTimestampMs()*int64(time.Millisecond))
+	}
+
+	return now
+}
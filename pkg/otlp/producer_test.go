@@ -0,0 +1,83 @@
+package otlp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestProducer(t *testing.T) {
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "test_calls_total",
+			Help: "Test counter",
+		},
+		[]string{"account"},
+	)
+
+	histogram := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "test_duration_seconds",
+			Help:    "Test histogram",
+			Buckets: []float64{1, 5},
+		},
+	)
+
+	counter.WithLabelValues("foo").Add(3)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+	histogram.Observe(10)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(counter, histogram)
+
+	scopes, err := (&producer{gatherer: registry}).Produce(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scopes) != 1 || len(scopes[0].Metrics) != 2 {
+		t.Fatalf("Expected %d metrics but got %v", 2, scopes)
+	}
+
+	metrics := scopes[0].Metrics
+
+	sum, ok := metrics[0].Data.(metricdata.Sum[float64])
+
+	if !ok || !sum.IsMonotonic || len(sum.DataPoints) != 1 {
+		t.Fatalf("Expected a monotonic sum but got %#v", metrics[0].Data)
+	}
+
+	if v, _ := sum.DataPoints[0].Attributes.Value(attribute.Key("account")); v.AsString() != "foo" {
+		t.Fatalf("Expected %s but got %s", "foo", v.AsString())
+	}
+
+	if sum.DataPoints[0].Value != 3 {
+		t.Fatalf("Expected %v but got %v", 3, sum.DataPoints[0].Value)
+	}
+
+	hist, ok := metrics[1].Data.(metricdata.Histogram[float64])
+
+	if !ok || len(hist.DataPoints) != 1 {
+		t.Fatalf("Expected an histogram but got %#v", metrics[1].Data)
+	}
+
+	point := hist.DataPoints[0]
+
+	if !reflect.DeepEqual(point.Bounds, []float64{1, 5}) {
+		t.Fatalf("Expected %v but got %v", []float64{1, 5}, point.Bounds)
+	}
+
+	if !reflect.DeepEqual(point.BucketCounts, []uint64{1, 1, 1}) {
+		t.Fatalf("Expected %v but got %v", []uint64{1, 1, 1}, point.BucketCounts)
+	}
+
+	if point.Count != 3 || point.Sum != 12.5 {
+		t.Fatalf("Expected %d/%v but got %d/%v", 3, 12.5, point.Count, point.Sum)
+	}
+}
@@ -0,0 +1,186 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+const (
+	defaultJob          = "prometheus-azure-exporter"
+	defaultTimeout      = 30 * time.Second
+	defaultRetryBackoff = time.Second
+)
+
+var (
+	// Mutex used to lock read/writes of pushers.
+	mutex = sync.RWMutex{}
+	// Pushers built from the current config.
+	pushers = make([]Pusher, 0)
+)
+
+// Pusher is the interface implemented by the push targets.
+type Pusher interface {
+	// Name of the pusher used in logs.
+	Name() string
+	// Push gathers metrics from g and sends them, module is the name of the
+	// exporter module the metrics belong to.
+	Push(ctx context.Context, module string, g prometheus.Gatherer) error
+}
+
+// ApplyConfig replaces the current pushers with the ones described in conf.
+func ApplyConfig(conf config.PushConfig) {
+	next := make([]Pusher, 0)
+
+	if conf.RemoteWrite != nil {
+		next = append(next, NewRemoteWriter(*conf.RemoteWrite))
+	}
+
+	if conf.Pushgateway != nil {
+		next = append(next, NewPushgateway(*conf.Pushgateway))
+	}
+
+	mutex.Lock()
+	pushers = next
+	mutex.Unlock()
+}
+
+// Push sends the metrics gathered from g to all the configured pushers.
+// Errors are logged, they do not interrupt the other pushers.
+func Push(ctx context.Context, module string, g prometheus.Gatherer) {
+	mutex.RLock()
+	current := pushers
+	mutex.RUnlock()
+
+	for _, pusher := range current {
+		contextLogger := log.WithFields(log.Fields{
+			"_id":    ctx.Value("id"),
+			"pusher": pusher.Name(),
+			"module": module,
+		})
+
+		t0 := time.Now()
+		err := pusher.Push(ctx, module, g)
+		t1 := time.Since(t0)
+
+		if err != nil {
+			contextLogger.Errorf("Unable to push metrics: %s", err)
+			continue
+		}
+
+		contextLogger.Debugf("Metrics pushed in %v", t1.Round(time.Millisecond))
+	}
+}
+
+// jobName returns the job label of the metrics pushed to the target.
+func jobName(conf config.PushTargetConfig) string {
+	if len(conf.Job) == 0 {
+		return defaultJob
+	}
+
+	return conf.Job
+}
+
+// instanceName returns the instance label of the metrics pushed, i.e. the
+// hostname, so that several exporters pushing to the same target do not
+// overwrite each other.
+func instanceName() string {
+	hostname, err := os.Hostname()
+
+	if err != nil {
+		log.Warnf("Unable to get hostname for the instance label: %s", err)
+	}
+
+	return hostname
+}
+
+// retryDoer is an http client adding configured headers to requests and
+// retrying them on network errors, 429 and 5xx responses.
+type retryDoer struct {
+	client       *http.Client
+	ctx          context.Context
+	headers      map[string]string
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+func newRetryDoer(ctx context.Context, conf config.PushTargetConfig) *retryDoer {
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	retryBackoff := conf.RetryBackoff
+	if retryBackoff == 0 {
+		retryBackoff = defaultRetryBackoff
+	}
+
+	return &retryDoer{
+		client:       &http.Client{Timeout: timeout},
+		ctx:          ctx,
+		headers:      conf.Headers,
+		maxRetries:   conf.MaxRetries,
+		retryBackoff: retryBackoff,
+	}
+}
+
+// Do sends the request, the request body is replayed thanks to req.GetBody.
+func (d *retryDoer) Do(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(d.ctx)
+
+	for k, v := range d.headers {
+		req.Header.Set(k, v)
+	}
+
+	// Requests whose body can not be replayed are not retried.
+	maxRetries := d.maxRetries
+	if req.Body != nil && req.GetBody == nil {
+		maxRetries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+
+			if err != nil {
+				return nil, err
+			}
+
+			req.Body = body
+		}
+
+		resp, err := d.client.Do(req)
+
+		retry := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+
+		if !retry || attempt >= maxRetries {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(d.retryBackoff * time.Duration(1<<uint(attempt))):
+		case <-d.ctx.Done():
+			return nil, d.ctx.Err()
+		}
+	}
+}
+
+// checkResponse returns an error if the response is not a 2xx.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package push
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func newTestRegistry() *prometheus.Registry {
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "test_calls_total",
+			Help: "Test counter",
+		},
+		[]string{"account"},
+	)
+
+	counter.WithLabelValues("foo").Add(3)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(counter)
+
+	return registry
+}
+
+// decodeWriteRequest decodes a WriteRequest into a map of series, keyed by
+// their labels formatted as `name=value,...`, to their value.
+func decodeWriteRequest(t *testing.T, b []byte) map[string]float64 {
+	series := make(map[string]float64)
+
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeTag(b)
+		ts, m := protowire.ConsumeBytes(b[n:])
+		b = b[n+m:]
+
+		labels := make([]string, 0)
+		value := math.NaN()
+
+		for len(ts) > 0 {
+			num, _, n := protowire.ConsumeTag(ts)
+			field, m := protowire.ConsumeBytes(ts[n:])
+			ts = ts[n+m:]
+
+			switch num {
+			case 1:
+				_, _, n := protowire.ConsumeTag(field)
+				name, m := protowire.ConsumeString(field[n:])
+				field = field[n+m:]
+				_, _, n = protowire.ConsumeTag(field)
+				val, _ := protowire.ConsumeString(field[n:])
+				labels = append(labels, name+"="+val)
+			case 2:
+				_, _, n := protowire.ConsumeTag(field)
+				v, _ := protowire.ConsumeFixed64(field[n:])
+				value = math.Float64frombits(v)
+			default:
+				t.Fatalf("Unexpected field %d in TimeSeries", num)
+			}
+		}
+
+		series[strings.Join(labels, ",")] = value
+	}
+
+	return series
+}
+
+func TestRemoteWriter(t *testing.T) {
+	var calls int32
+
+	hostname, _ := os.Hostname()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first call to check retries.
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("Expected snappy encoding but got %s", r.Header.Get("Content-Encoding"))
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Expected authorization header but got %s", r.Header.Get("Authorization"))
+		}
+
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+
+		if err != nil {
+			t.Errorf("Unable to decode body: %s", err)
+		}
+
+		series := decodeWriteRequest(t, body)
+		expected := "__name__=test_calls_total,account=foo,env=test,instance=" + hostname + ",job=prometheus-azure-exporter"
+
+		if v, ok := series[expected]; !ok || v != 3 {
+			t.Errorf("Expected %s to be 3 but got %v", expected, series)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer := NewRemoteWriter(config.PushTargetConfig{
+		URL:            server.URL,
+		Headers:        map[string]string{"Authorization": "Bearer token"},
+		ExternalLabels: map[string]string{"env": "test"},
+		MaxRetries:     1,
+		RetryBackoff:   time.Millisecond,
+	})
+
+	if err := writer.Push(context.Background(), "batch", newTestRegistry()); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("Expected %d calls but got %d", 2, calls)
+	}
+}
+
+func TestPushgateway(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("Expected %s but got %s", http.MethodPut, r.Method)
+		}
+
+		if !strings.HasPrefix(r.URL.Path, "/metrics/job/azure/") || !strings.Contains(r.URL.Path, "/module/batch") || !strings.Contains(r.URL.Path, "/instance/") {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	pushgateway := NewPushgateway(config.PushTargetConfig{
+		URL: server.URL,
+		Job: "azure",
+	})
+
+	if err := pushgateway.Push(context.Background(), "batch", newTestRegistry()); err != nil {
+		t.Fatal(err)
+	}
+}
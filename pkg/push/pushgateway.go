@@ -0,0 +1,42 @@
+package push
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+)
+
+// Pushgateway pushes metrics to a Prometheus Pushgateway.
+type Pushgateway struct {
+	conf config.PushTargetConfig
+}
+
+// NewPushgateway returns a Pushgateway pushing to conf.URL.
+func NewPushgateway(conf config.PushTargetConfig) *Pushgateway {
+	return &Pushgateway{
+		conf: conf,
+	}
+}
+
+// Name ...
+func (p *Pushgateway) Name() string {
+	return "pushgateway"
+}
+
+// Push replaces the metrics of the module grouping key with the ones
+// gathered from g. External labels are added to the grouping key.
+func (p *Pushgateway) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	pusher := push.New(p.conf.URL, jobName(p.conf)).
+		Gatherer(g).
+		Client(newRetryDoer(ctx, p.conf)).
+		Grouping("instance", instanceName()).
+		Grouping("module", module)
+
+	for name, value := range p.conf.ExternalLabels {
+		pusher = pusher.Grouping(name, value)
+	}
+
+	return pusher.Push()
+}
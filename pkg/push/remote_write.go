@@ -0,0 +1,212 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriter pushes metrics using the Prometheus remote write protocol.
+type RemoteWriter struct {
+	conf config.PushTargetConfig
+}
+
+// label is a name/value pair of a remote write time series.
+type label struct {
+	name  string
+	value string
+}
+
+// sample is a remote write time series with a single value.
+type sample struct {
+	labels []label
+	value  float64
+}
+
+// NewRemoteWriter returns a RemoteWriter pushing to conf.URL.
+func NewRemoteWriter(conf config.PushTargetConfig) *RemoteWriter {
+	return &RemoteWriter{
+		conf: conf,
+	}
+}
+
+// Name ...
+func (w *RemoteWriter) Name() string {
+	return "remote_write"
+}
+
+// Push gathers metrics from g and sends them as a snappy compressed protobuf
+// WriteRequest.
+func (w *RemoteWriter) Push(ctx context.Context, module string, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+
+	if err != nil {
+		return err
+	}
+
+	// Series carry the same job and instance labels as the Pushgateway
+	// grouping key, external labels can override them.
+	targetLabels := map[string]string{
+		"job":      jobName(w.conf),
+		"instance": instanceName(),
+	}
+
+	for k, v := range w.conf.ExternalLabels {
+		targetLabels[k] = v
+	}
+
+	samples := familiesToSamples(mfs, targetLabels)
+	body := snappy.Encode(nil, encodeWriteRequest(samples, time.Now()))
+
+	req, err := http.NewRequest(http.MethodPost, w.conf.URL, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "prometheus-azure-exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := newRetryDoer(ctx, w.conf).Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	return checkResponse(resp)
+}
+
+// familiesToSamples flattens metric families into samples the same way
+// Prometheus does when scraping the text format.
+func familiesToSamples(mfs []*dto.MetricFamily, targetLabels map[string]string) []sample {
+	samples := make([]sample, 0)
+
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			newSample := func(suffix string, value float64, extra ...label) {
+				labels := make([]label, 0, len(m.GetLabel())+len(targetLabels)+len(extra)+1)
+				labels = append(labels, label{"__name__", mf.GetName() + suffix})
+
+				for k, v := range targetLabels {
+					labels = append(labels, label{k, v})
+				}
+
+				// Metric labels take precedence over target labels.
+				for _, l := range m.GetLabel() {
+					labels = setLabel(labels, l.GetName(), l.GetValue())
+				}
+
+				for _, l := range extra {
+					labels = setLabel(labels, l.name, l.value)
+				}
+
+				sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+				samples = append(samples, sample{labels: labels, value: value})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				newSample("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				newSample("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				newSample("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().GetQuantile() {
+					newSample("", q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				newSample("_sum", m.GetSummary().GetSampleSum())
+				newSample("_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				infSeen := false
+				for _, b := range m.GetHistogram().GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					newSample("_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					newSample("_bucket", float64(m.GetHistogram().GetSampleCount()), label{"le", "+Inf"})
+				}
+				newSample("_sum", m.GetHistogram().GetSampleSum())
+				newSample("_count", float64(m.GetHistogram().GetSampleCount()))
+			}
+		}
+	}
+
+	return samples
+}
+
+// setLabel overrides the value of the label if it exists or appends it.
+func setLabel(labels []label, name string, value string) []label {
+	for i := range labels {
+		if labels[i].name == name {
+			labels[i].value = value
+			return labels
+		}
+	}
+
+	return append(labels, label{name, value})
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes samples as a prometheus.WriteRequest protobuf:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(samples []sample, t time.Time) []byte {
+	timestamp := t.UnixNano() / int64(time.Millisecond)
+	buf := make([]byte, 0)
+
+	for _, s := range samples {
+		ts := make([]byte, 0)
+
+		for _, l := range s.labels {
+			lb := make([]byte, 0, len(l.name)+len(l.value)+4)
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+
+		sb := make([]byte, 0, 20)
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(timestamp))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+
+	return buf
+}
@@ -0,0 +1,160 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+	"github.com/sylr/prometheus-azure-exporter/pkg/otlp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName     = "github.com/sylr/prometheus-azure-exporter"
+	defaultTimeout = 30 * time.Second
+)
+
+var (
+	// Mutex used to lock read/writes of currentConfig/provider.
+	mutex = sync.Mutex{}
+	// Tracing config currently applied, nil if tracing is disabled.
+	currentConfig *config.TracingConfig
+	// Tracer provider exporting spans.
+	provider *sdktrace.TracerProvider
+)
+
+// Tracer returns the tracer used to create spans, spans are dropped if
+// tracing is disabled.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// ApplyConfig starts exporting spans to the collector described in conf,
+// a nil conf disables tracing. The exporter is only restarted if the config
+// changed.
+func ApplyConfig(conf *config.TracingConfig) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if reflect.DeepEqual(conf, currentConfig) {
+		return nil
+	}
+
+	var next *sdktrace.TracerProvider
+
+	if conf != nil {
+		var err error
+		next, err = newTracerProvider(conf)
+
+		if err != nil {
+			return err
+		}
+
+		otel.SetTracerProvider(next)
+	} else if provider != nil {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	}
+
+	shutdown(provider)
+	currentConfig = conf
+	provider = next
+
+	return nil
+}
+
+// Shutdown exports the pending spans and stops the export.
+func Shutdown() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	}
+
+	shutdown(provider)
+	currentConfig = nil
+	provider = nil
+}
+
+func shutdown(p *sdktrace.TracerProvider) {
+	if p == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// Errors are reported to the error handler.
+	p.Shutdown(ctx)
+}
+
+func newTracerProvider(conf *config.TracingConfig) (*sdktrace.TracerProvider, error) {
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	ratio := 1.0
+	if conf.SampleRatio != nil {
+		ratio = *conf.SampleRatio
+	}
+
+	exporter, err := newExporter(conf, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := otlp.NewResource(conf.ResourceAttributes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter, sdktrace.WithExportTimeout(timeout)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	), nil
+}
+
+func newExporter(conf *config.TracingConfig, timeout time.Duration) (sdktrace.SpanExporter, error) {
+	ctx := context.Background()
+
+	switch conf.Protocol {
+	case "", "grpc":
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(conf.Endpoint),
+			otlptracegrpc.WithHeaders(conf.Headers),
+			otlptracegrpc.WithTimeout(timeout),
+		}
+
+		if conf.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		return otlptracegrpc.New(ctx, opts...)
+	case "http":
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(conf.Endpoint),
+			otlptracehttp.WithHeaders(conf.Headers),
+			otlptracehttp.WithTimeout(timeout),
+		}
+
+		if conf.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("tracing: unknown protocol `%s`", conf.Protocol)
+	}
+}
@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sylr/prometheus-azure-exporter/pkg/config"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func currentProvider() *sdktrace.TracerProvider {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return provider
+}
+
+func TestApplyConfig(t *testing.T) {
+	defer Shutdown()
+
+	if err := ApplyConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if currentProvider() != nil {
+		t.Fatal("expected no tracer provider without config")
+	}
+
+	conf := &config.TracingConfig{Endpoint: "127.0.0.1:4317", Insecure: true}
+
+	if err := ApplyConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	first := currentProvider()
+
+	if first == nil || otel.GetTracerProvider() != first {
+		t.Fatal("expected the tracer provider to be set globally")
+	}
+
+	// The same config does not restart the export.
+	if err := ApplyConfig(&config.TracingConfig{Endpoint: "127.0.0.1:4317", Insecure: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if currentProvider() != first {
+		t.Error("expected the tracer provider to be kept when the config did not change")
+	}
+
+	if err := ApplyConfig(&config.TracingConfig{Endpoint: "127.0.0.1:4318", Protocol: "http", Insecure: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	second := currentProvider()
+
+	if second == nil || second == first || otel.GetTracerProvider() != second {
+		t.Error("expected a new tracer provider when the config changed")
+	}
+
+	// An invalid config keeps the current one.
+	if err := ApplyConfig(&config.TracingConfig{Endpoint: "127.0.0.1:4317", Protocol: "udp"}); err == nil {
+		t.Error("expected an error for an unknown protocol")
+	}
+
+	if currentProvider() != second {
+		t.Error("expected the tracer provider to be kept when the config is invalid")
+	}
+
+	if err := ApplyConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if currentProvider() != nil {
+		t.Error("expected no tracer provider once tracing is disabled")
+	}
+
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		t.Error("expected spans to be dropped once tracing is disabled")
+	}
+}
+
+func TestTracer(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	ctx, root := Tracer().Start(context.Background(), "root")
+	_, child := Tracer().Start(ctx, "child")
+	child.End()
+	root.End()
+
+	spans := recorder.Ended()
+
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	if spans[0].Parent().SpanID() != spans[1].SpanContext().SpanID() {
+		t.Error("expected the child span to be parented to the root span")
+	}
+
+	if spans[1].InstrumentationScope().Name != tracerName {
+		t.Errorf("instrumentation scope = %s, want %s", spans[1].InstrumentationScope().Name, tracerName)
+	}
+}
@@ -0,0 +1,161 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"":                           tls.NoClientCert,
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+
+	tlsVersions = map[string]uint16{
+		"":      tls.VersionTLS12,
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+)
+
+// Config is the content of the web config file given with --web-config.
+// The format is compatible with the one used by the prometheus exporter toolkit.
+type Config struct {
+	TLSConfig TLSConfig         `yaml:"tls_server_config"`
+	Users     map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSConfig holds the TLS settings of the metrics listener.
+type TLSConfig struct {
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ClientAuth string `yaml:"client_auth_type"`
+	ClientCAs  string `yaml:"client_ca_file"`
+	MinVersion string `yaml:"min_version"`
+}
+
+// LoadFile parses the given YAML file into a Config.
+func LoadFile(filename string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	err = yaml.UnmarshalStrict(content, cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing YAML file %s: %v", filename, err)
+	}
+
+	// Relative paths are relative to the web config file.
+	dir := filepath.Dir(filename)
+	cfg.TLSConfig.CertFile = joinDir(dir, cfg.TLSConfig.CertFile)
+	cfg.TLSConfig.KeyFile = joinDir(dir, cfg.TLSConfig.KeyFile)
+	cfg.TLSConfig.ClientCAs = joinDir(dir, cfg.TLSConfig.ClientCAs)
+
+	return cfg, nil
+}
+
+// TLSEnabled returns true if the config requires the listener to use TLS.
+func (c *Config) TLSEnabled() bool {
+	return c != nil && (len(c.TLSConfig.CertFile) > 0 || len(c.TLSConfig.KeyFile) > 0)
+}
+
+// Validate returns a []error if the config contains settings which do not
+// make sens or cannot be applied.
+func (c *Config) Validate() []error {
+	errs := make([]error, 0)
+
+	if c == nil {
+		return errs
+	}
+
+	if len(c.TLSConfig.CertFile) == 0 && len(c.TLSConfig.KeyFile) > 0 {
+		errs = append(errs, errors.New("web: missing cert_file"))
+	}
+
+	if len(c.TLSConfig.CertFile) > 0 && len(c.TLSConfig.KeyFile) == 0 {
+		errs = append(errs, errors.New("web: missing key_file"))
+	}
+
+	if _, ok := clientAuthTypes[c.TLSConfig.ClientAuth]; !ok {
+		errs = append(errs, fmt.Errorf("web: `%s` is not a valid client_auth_type", c.TLSConfig.ClientAuth))
+	}
+
+	if _, ok := tlsVersions[c.TLSConfig.MinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("web: `%s` is not a valid min_version", c.TLSConfig.MinVersion))
+	}
+
+	if len(c.TLSConfig.ClientCAs) > 0 && !c.TLSEnabled() {
+		errs = append(errs, errors.New("web: client_ca_file requires cert_file and key_file"))
+	}
+
+	if len(c.TLSConfig.ClientCAs) == 0 && clientAuthTypes[c.TLSConfig.ClientAuth] >= tls.VerifyClientCertIfGiven {
+		errs = append(errs, fmt.Errorf("web: client_auth_type `%s` requires client_ca_file", c.TLSConfig.ClientAuth))
+	}
+
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			errs = append(errs, fmt.Errorf("web: password of user `%s` is not a valid bcrypt hash: %s", user, err))
+		}
+	}
+
+	return errs
+}
+
+// newTLSConfig loads the certificates referenced by the config and returns
+// the matching *tls.Config.
+func (c *Config) newTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.TLSConfig.CertFile, c.TLSConfig.KeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("web: unable to load certificate: %s", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuthTypes[c.TLSConfig.ClientAuth],
+		MinVersion:   tlsVersions[c.TLSConfig.MinVersion],
+	}
+
+	if len(c.TLSConfig.ClientCAs) > 0 {
+		content, err := ioutil.ReadFile(c.TLSConfig.ClientCAs)
+
+		if err != nil {
+			return nil, fmt.Errorf("web: unable to read client CA file: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("web: no certificate found in client CA file %s", c.TLSConfig.ClientCAs)
+		}
+
+		tlsConfig.ClientCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+func joinDir(dir string, path string) string {
+	if len(path) == 0 || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
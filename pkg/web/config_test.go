@@ -0,0 +1,224 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidate(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+
+	conf := &Config{
+		TLSConfig: TLSConfig{
+			CertFile:   "cert.pem",
+			KeyFile:    "key.pem",
+			ClientAuth: "RequireAndVerifyClientCert",
+			ClientCAs:  "ca.pem",
+			MinVersion: "TLS13",
+		},
+		Users: map[string]string{
+			"prometheus": string(hash),
+		},
+	}
+
+	if errs := conf.Validate(); len(errs) != 0 {
+		t.Fatalf("Expected no error but got %v", errs)
+	}
+
+	conf = &Config{
+		TLSConfig: TLSConfig{
+			KeyFile:    "key.pem",
+			ClientAuth: "RequireAndVerifyClientCert",
+			MinVersion: "SSL3",
+		},
+		Users: map[string]string{
+			"prometheus": "secret",
+		},
+	}
+
+	if errs := conf.Validate(); len(errs) != 4 {
+		t.Fatalf("Expected %d errors but got %v", 4, errs)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+
+	err := ApplyConfig(&Config{
+		Users: map[string]string{
+			"prometheus": string(hash),
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ApplyConfig(nil)
+
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		user     string
+		password string
+		code     int
+	}{
+		{"prometheus", "secret", http.StatusOK},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"unknown", "secret", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+
+		if len(test.user) > 0 {
+			req.SetBasicAuth(test.user, test.password)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Fatalf("Expected %d but got %d for user `%s`", test.code, rec.Code, test.user)
+		}
+	}
+
+	if !authCached(authCacheKey("prometheus", string(hash), "secret")) {
+		t.Fatal("Expected the valid credentials to be cached")
+	}
+
+	if authCached(authCacheKey("prometheus", string(hash), "wrong")) {
+		t.Fatal("Expected the invalid credentials not to be cached")
+	}
+}
+
+func TestAuthCacheSize(t *testing.T) {
+	for i := 0; i < authCacheSize*2; i++ {
+		cacheAuth(authCacheKey("prometheus", "hash", strconv.Itoa(i)))
+	}
+
+	authCacheMutex.Lock()
+	size := len(authCache)
+	authCacheMutex.Unlock()
+
+	if size != authCacheSize {
+		t.Fatalf("Expected %d cached credentials but got %d", authCacheSize, size)
+	}
+}
+
+// writeCertificate writes a self signed certificate with the given serial
+// number and its key in dir.
+func writeCertificate(t *testing.T, dir string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	writeCertificate(t, dir, 1)
+
+	conf := &Config{
+		TLSConfig: TLSConfig{
+			CertFile: filepath.Join(dir, "cert.pem"),
+			KeyFile:  filepath.Join(dir, "key.pem"),
+		},
+	}
+
+	if err := ApplyConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	defer ApplyConfig(nil)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		GetCertificate:     getCertificate,
+		GetConfigForClient: getConfigForClient,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+
+	serial := func() int64 {
+		resp, err := client.Get(server.URL)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if got := serial(); got != 1 {
+		t.Fatalf("Expected certificate 1 but got %d", got)
+	}
+
+	writeCertificate(t, dir, 2)
+
+	if err := ApplyConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := serial(); got != 2 {
+		t.Fatalf("Expected certificate 2 after reload but got %d", got)
+	}
+}
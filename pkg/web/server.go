@@ -0,0 +1,202 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// Mutex used to lock read/writes of currentConfig/currentTLSConfig.
+	mutex = sync.RWMutex{}
+	// Web config currently applied.
+	currentConfig *Config
+	// TLS config built from currentConfig, nil if TLS is disabled.
+	currentTLSConfig *tls.Config
+	// Whether the listener has been started or not, once started we can not
+	// switch between http and https anymore.
+	serving = false
+	// Hash compared against when the user is unknown so that response time
+	// does not tell whether a user exists or not.
+	dummyHash, _ = bcrypt.GenerateFromPassword([]byte("prometheus-azure-exporter"), bcrypt.DefaultCost)
+	// Mutex used to lock read/writes of authCache.
+	authCacheMutex = sync.Mutex{}
+	// Keys of the credentials which passed the bcrypt comparison, bcrypt is
+	// slow by design and would otherwise run on every scrape.
+	authCache = make(map[string]struct{})
+)
+
+const (
+	// Maximum number of credentials kept in authCache.
+	authCacheSize = 100
+)
+
+// ApplyConfig swaps the current web config with the given one. Certificates
+// and users are reloaded but TLS can not be turned on or off once the
+// listener has been started.
+func ApplyConfig(conf *Config) error {
+	var tlsConfig *tls.Config
+	var err error
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if serving && currentConfig.TLSEnabled() != conf.TLSEnabled() {
+		return errors.New("web: cannot enable or disable TLS on a running listener")
+	}
+
+	if conf.TLSEnabled() {
+		tlsConfig, err = conf.newTLSConfig()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	currentConfig = conf
+	currentTLSConfig = tlsConfig
+
+	return nil
+}
+
+// getConfigForClient returns the TLS config currently applied, it is called
+// on every TLS handshake which allows to reload certificates.
+func getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return currentTLSConfig, nil
+}
+
+// getCertificate returns the certificate currently applied.
+func getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if currentTLSConfig == nil || len(currentTLSConfig.Certificates) == 0 {
+		return nil, errors.New("web: no certificate loaded")
+	}
+
+	return &currentTLSConfig.Certificates[0], nil
+}
+
+// Handler wraps h with basic authentication if users are defined in the
+// current web config.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.RLock()
+		users := currentConfig.users()
+		mutex.RUnlock()
+
+		if len(users) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		user, pass, ok := r.BasicAuth()
+		hash, found := users[user]
+
+		if !found {
+			hash = string(dummyHash)
+		}
+
+		key := authCacheKey(user, hash, pass)
+		authenticated := ok && found && authCached(key)
+
+		if !authenticated {
+			err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
+			authenticated = ok && found && err == nil
+
+			if authenticated {
+				cacheAuth(key)
+			}
+		}
+
+		if !authenticated {
+			w.Header().Set("WWW-Authenticate", `Basic realm="prometheus-azure-exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// authCacheKey returns the key of the credentials in authCache. The bcrypt hash
+// is part of it so that changing the password of a user invalidates the key.
+func authCacheKey(user string, hash string, pass string) string {
+	sum := sha256.Sum256([]byte(user + ":" + hash + ":" + pass))
+	return hex.EncodeToString(sum[:])
+}
+
+// authCached tells whether the credentials of key already passed the bcrypt
+// comparison.
+func authCached(key string) bool {
+	authCacheMutex.Lock()
+	defer authCacheMutex.Unlock()
+
+	_, ok := authCache[key]
+
+	return ok
+}
+
+// cacheAuth adds key to authCache, evicting a random key if it is full.
+func cacheAuth(key string) {
+	authCacheMutex.Lock()
+	defer authCacheMutex.Unlock()
+
+	if len(authCache) >= authCacheSize {
+		for k := range authCache {
+			delete(authCache, k)
+			break
+		}
+	}
+
+	authCache[key] = struct{}{}
+}
+
+// ListenAndServe starts server with TLS if the current web config requires it.
+func ListenAndServe(server *http.Server) error {
+	mutex.Lock()
+	serving = true
+	tlsEnabled := currentConfig.TLSEnabled()
+	mutex.Unlock()
+
+	if !tlsEnabled {
+		return server.ListenAndServe()
+	}
+
+	// Certificates are provided by the callbacks.
+	server.TLSConfig = &tls.Config{
+		GetCertificate:     getCertificate,
+		GetConfigForClient: getConfigForClient,
+	}
+
+	return server.ListenAndServeTLS("", "")
+}
+
+// NewDebugHandler returns an handler exposing net/http/pprof endpoints.
+func NewDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+func (c *Config) users() map[string]string {
+	if c == nil {
+		return nil
+	}
+
+	return c.Users
+}